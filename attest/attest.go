@@ -0,0 +1,212 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package attest implements verification of AMD SEV-SNP attestation reports
+// issued by the tamago-sev-example unikernel.
+//
+// The package does not depend on the TamaGo runtime, it can therefore be used
+// by host-side Go code (relying parties) as well as within the unikernel.
+package attest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-sev-guest/verify"
+
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// ReportDataSize represents the size of the attestation report REPORT_DATA
+// field.
+const ReportDataSize = 64
+
+// Options represents attestation report verification options.
+type Options struct {
+	// Verify represents the report signature verification options, when
+	// nil [verify.DefaultOptions] is used (which requires network access
+	// to the AMD Key Distribution Service) unless Chain is set.
+	Verify *verify.Options
+
+	// Chain is the endorsement key certificate chain for offline
+	// verification, when set certificate fetching from the AMD Key
+	// Distribution Service is disabled (see [ParseChain]).
+	Chain *spb.CertificateChain
+
+	// Measurements is the list of allowed launch measurements, unchecked
+	// if empty.
+	Measurements [][]byte
+
+	// IDKeyDigests is the list of allowed ID key digests, unchecked if
+	// empty (see [KeyDigest]).
+	IDKeyDigests [][]byte
+
+	// AuthorKeyDigests is the list of allowed author key digests,
+	// unchecked if empty (see [KeyDigest]).
+	AuthorKeyDigests [][]byte
+
+	// MinimumTCB is the component-wise minimum for the current, reported
+	// and committed TCB versions.
+	MinimumTCB kds.TCBParts
+
+	// VMPL is the expected VM Privilege Level, unchecked if nil.
+	VMPL *int
+
+	// AllowDebug permits reports of guests launched with the debug policy
+	// bit set.
+	AllowDebug bool
+}
+
+// Result represents a successful attestation report verification.
+type Result struct {
+	// Report is the verified attestation report.
+	Report *spb.Report
+	// Signer is the report signing key type.
+	Signer abi.ReportSigner
+	// Offline reports whether the certificate chain was provided by the
+	// caller, rather than fetched from the AMD Key Distribution Service.
+	Offline bool
+
+	// Policy is the decoded guest policy.
+	Policy abi.SnpPolicy
+	// CurrentTCB is the decoded current TCB version.
+	CurrentTCB kds.TCBParts
+	// ReportedTCB is the decoded reported TCB version.
+	ReportedTCB kds.TCBParts
+	// CommittedTCB is the decoded committed TCB version.
+	CommittedTCB kds.TCBParts
+}
+
+// Verify parses and verifies a raw attestation report, its REPORT_DATA field
+// must match the argument data (zero padded to [ReportDataSize]). The report
+// is then checked against the policy expressed in the argument options.
+func Verify(raw []byte, data []byte, opts *Options) (res *Result, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if len(data) > ReportDataSize {
+		return nil, fmt.Errorf("invalid report data size (%d > %d)", len(data), ReportDataSize)
+	}
+
+	res = &Result{
+		Offline: opts.Chain != nil,
+	}
+
+	if res.Report, err = abi.ReportToProto(raw); err != nil {
+		return nil, fmt.Errorf("could not parse report, %v", err)
+	}
+
+	reportData := make([]byte, ReportDataSize)
+	copy(reportData, data)
+
+	if !bytes.Equal(res.Report.ReportData, reportData) {
+		return nil, fmt.Errorf("report data mismatch")
+	}
+
+	if err = verifySignature(res, opts); err != nil {
+		return nil, fmt.Errorf("could not verify report, %v", err)
+	}
+
+	if err = check(res, opts); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func verifySignature(res *Result, opts *Options) (err error) {
+	verifyOptions := opts.Verify
+
+	switch {
+	case verifyOptions != nil && res.Offline:
+		o := *verifyOptions
+		o.DisableCertFetching = true
+		verifyOptions = &o
+	case res.Offline:
+		verifyOptions = &verify.Options{
+			DisableCertFetching: true,
+		}
+	case verifyOptions == nil:
+		verifyOptions = verify.DefaultOptions()
+	}
+
+	info, err := abi.ParseSignerInfo(res.Report.SignerInfo)
+
+	if err != nil {
+		return
+	}
+
+	res.Signer = info.SigningKey
+
+	attestation := &spb.Attestation{
+		Report:           res.Report,
+		CertificateChain: opts.Chain,
+	}
+
+	if attestation.CertificateChain == nil {
+		attestation.CertificateChain = &spb.CertificateChain{}
+	}
+
+	return verify.SnpAttestation(attestation, verifyOptions)
+}
+
+func check(res *Result, opts *Options) (err error) {
+	r := res.Report
+
+	if res.Policy, err = abi.ParseSnpPolicy(r.Policy); err != nil {
+		return fmt.Errorf("invalid guest policy, %v", err)
+	}
+
+	res.CurrentTCB = kds.DecomposeTCBVersion(kds.TCBVersion(r.CurrentTcb))
+	res.ReportedTCB = kds.DecomposeTCBVersion(kds.TCBVersion(r.ReportedTcb))
+	res.CommittedTCB = kds.DecomposeTCBVersion(kds.TCBVersion(r.CommittedTcb))
+
+	if len(opts.Measurements) > 0 && !contains(opts.Measurements, r.Measurement) {
+		return fmt.Errorf("measurement not allowed (%x)", r.Measurement)
+	}
+
+	if len(opts.IDKeyDigests) > 0 && !contains(opts.IDKeyDigests, r.IdKeyDigest) {
+		return fmt.Errorf("ID key not allowed (%x)", r.IdKeyDigest)
+	}
+
+	if len(opts.AuthorKeyDigests) > 0 && !contains(opts.AuthorKeyDigests, r.AuthorKeyDigest) {
+		return fmt.Errorf("author key not allowed (%x)", r.AuthorKeyDigest)
+	}
+
+	if !kds.TCBPartsLE(opts.MinimumTCB, res.CurrentTCB) {
+		return fmt.Errorf("current TCB below minimum (%+v)", res.CurrentTCB)
+	}
+
+	if !kds.TCBPartsLE(opts.MinimumTCB, res.ReportedTCB) {
+		return fmt.Errorf("reported TCB below minimum (%+v)", res.ReportedTCB)
+	}
+
+	if !kds.TCBPartsLE(opts.MinimumTCB, res.CommittedTCB) {
+		return fmt.Errorf("committed TCB below minimum (%+v)", res.CommittedTCB)
+	}
+
+	if opts.VMPL != nil && int(r.Vmpl) != *opts.VMPL {
+		return fmt.Errorf("VMPL mismatch (%d)", r.Vmpl)
+	}
+
+	if res.Policy.Debug && !opts.AllowDebug {
+		return fmt.Errorf("debug policy not allowed")
+	}
+
+	return
+}
+
+func contains(list [][]byte, val []byte) bool {
+	for _, v := range list {
+		if bytes.Equal(v, val) {
+			return true
+		}
+	}
+
+	return false
+}
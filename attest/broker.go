@@ -0,0 +1,262 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// secretLabel is the HKDF label for secret wrapping keys.
+const secretLabel = "secret-wrap/aes-256-gcm/v1"
+
+// signatureLabel is the domain separation label for secret response
+// signatures.
+const signatureLabel = "secret-response/ed25519/v1"
+
+// maxSecretRequestSize is the maximum secret request size.
+const maxSecretRequestSize = 64 * 1024
+
+// SecretRequest represents a guest request for secret provisioning, the
+// attestation report REPORT_DATA must be the [SecretReportData] value of the
+// guest ephemeral public key.
+type SecretRequest struct {
+	// PublicKey is the guest ephemeral X25519 public key.
+	PublicKey []byte `json:"public_key"`
+	// Report is the raw attestation report.
+	Report []byte `json:"report"`
+	// Certificates is the certificate table supplied by the hypervisor,
+	// empty if not available (see [ParseCertTable]).
+	Certificates []byte `json:"certificates,omitempty"`
+}
+
+// SecretResponse represents secrets wrapped to a guest ephemeral public key
+// and signed by the broker identity key.
+type SecretResponse struct {
+	// PublicKey is the broker ephemeral X25519 public key.
+	PublicKey []byte `json:"public_key"`
+	// Nonce is the AES-GCM nonce.
+	Nonce []byte `json:"nonce"`
+	// Ciphertext is the AES-GCM encrypted JSON encoding of the secrets
+	// (name to value map).
+	Ciphertext []byte `json:"ciphertext"`
+	// Signature is the broker Ed25519 signature over the guest and broker
+	// ephemeral public keys, nonce and ciphertext.
+	Signature []byte `json:"signature"`
+}
+
+// signedData returns the response data covered by its signature, each field
+// is length prefixed to prevent ambiguous encodings.
+func (res *SecretResponse) signedData(guest []byte) (buf []byte) {
+	buf = []byte(signatureLabel)
+
+	for _, f := range [][]byte{guest, res.PublicKey, res.Nonce, res.Ciphertext} {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(f)))
+		buf = append(buf, f...)
+	}
+
+	return
+}
+
+// SecretReportData returns the attestation report REPORT_DATA value for a
+// secret request, as the SHA-512 hash of the guest ephemeral public key.
+func SecretReportData(pub []byte) []byte {
+	sum := sha512.Sum512(pub)
+	return sum[:]
+}
+
+// wrapCipher returns the AEAD instance for secrets wrapping, keyed with the
+// X25519 shared secret between the argument keys.
+func wrapCipher(priv *ecdh.PrivateKey, peer *ecdh.PublicKey, guest []byte, broker []byte) (aead cipher.AEAD, err error) {
+	shared, err := priv.ECDH(peer)
+
+	if err != nil {
+		return
+	}
+
+	salt := append(append([]byte{}, guest...), broker...)
+	key, err := hkdf.Key(sha256.New, shared, salt, secretLabel, 32)
+
+	if err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// WrapSecrets encrypts secrets to the argument guest ephemeral public key and
+// signs the response with the argument broker identity key.
+func WrapSecrets(key ed25519.PrivateKey, guest []byte, secrets map[string][]byte) (res *SecretResponse, err error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid broker key")
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(guest)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key, %v", err)
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return
+	}
+
+	res = &SecretResponse{
+		PublicKey: priv.PublicKey().Bytes(),
+	}
+
+	aead, err := wrapCipher(priv, peer, guest, res.PublicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not derive wrapping key, %v", err)
+	}
+
+	plaintext, err := json.Marshal(secrets)
+
+	if err != nil {
+		return
+	}
+
+	res.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(res.Nonce)
+
+	res.Ciphertext = aead.Seal(nil, res.Nonce, plaintext, res.PublicKey)
+	res.Signature = ed25519.Sign(key, res.signedData(guest))
+
+	return
+}
+
+// UnwrapSecrets verifies the response signature against the argument broker
+// identity public key and decrypts secrets wrapped to the argument guest
+// ephemeral private key.
+func UnwrapSecrets(priv *ecdh.PrivateKey, broker ed25519.PublicKey, res *SecretResponse) (secrets map[string][]byte, err error) {
+	if res == nil {
+		return nil, errors.New("missing response")
+	}
+
+	if len(broker) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid broker key")
+	}
+
+	if !ed25519.Verify(broker, res.signedData(priv.PublicKey().Bytes()), res.Signature) {
+		return nil, errors.New("invalid response signature")
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(res.PublicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key, %v", err)
+	}
+
+	aead, err := wrapCipher(priv, peer, priv.PublicKey().Bytes(), res.PublicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not derive wrapping key, %v", err)
+	}
+
+	if len(res.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, res.Nonce, res.Ciphertext, res.PublicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap secrets, %v", err)
+	}
+
+	err = json.Unmarshal(plaintext, &secrets)
+
+	return
+}
+
+// Broker represents a key broker, releasing secrets to guests which present
+// a valid attestation report.
+type Broker struct {
+	// Options represents the attestation report verification options.
+	Options *Options
+
+	// Key is the broker identity key, used to sign responses, its public
+	// key must be pinned in guests.
+	Key ed25519.PrivateKey
+
+	// Secrets returns the secrets to be released for a verified
+	// attestation report.
+	Secrets func(res *Result) (map[string][]byte, error)
+}
+
+// Release verifies a secret request and returns its wrapped secrets.
+func (b *Broker) Release(req *SecretRequest) (res *SecretResponse, err error) {
+	if b.Secrets == nil {
+		return nil, errors.New("no secrets available")
+	}
+
+	ev := &Evidence{
+		Report:       req.Report,
+		Certificates: req.Certificates,
+	}
+
+	r, err := VerifyEvidence(ev, SecretReportData(req.PublicKey), b.Options)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not verify report, %v", err)
+	}
+
+	secrets, err := b.Secrets(r)
+
+	if err != nil {
+		return
+	}
+
+	return WrapSecrets(b.Key, req.PublicKey, secrets)
+}
+
+// ServeHTTP implements the [http.Handler] interface for secret requests
+// (POST with JSON encoded [SecretRequest] body).
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &SecretRequest{}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSecretRequestSize)).Decode(req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	res, err := b.Release(req)
+
+	if err != nil {
+		log.Printf("secret request from %s rejected, %v", r.RemoteAddr, err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,266 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+
+	test "github.com/google/go-sev-guest/testing"
+)
+
+var testSecrets = map[string][]byte{
+	"disk-key":  []byte("0123456789abcdef"),
+	"api-token": []byte("token"),
+}
+
+func testBrokerKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pub, priv
+}
+
+func testGuestKey(t *testing.T) *ecdh.PrivateKey {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return priv
+}
+
+// testReport returns a raw attestation report, signed by a test-only
+// endorsement key, with the argument measurement and REPORT_DATA along with
+// its certificate table and the matching verification options.
+func testReport(t *testing.T, measurement []byte, data []byte) (raw []byte, certs []byte, opts *verify.Options) {
+	signer, err := test.DefaultTestOnlyCertChain(test.GetProductName(), time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := test.CreateRawReport(&test.TestReportOptions{ReportData: data})
+	copy(report[0x90:0xc0], measurement)
+	raw = report[:abi.ReportSize]
+
+	r, s, err := signer.Sign(abi.SignedComponent(raw))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = abi.SetSignature(r, s, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if certs, err = signer.CertTableBytes(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := trust.AMDRootCertsProduct(test.GetProductLine())
+	root.ProductCerts = &trust.ProductCerts{
+		Ark: signer.Ark,
+		Ask: signer.Ask,
+	}
+
+	opts = &verify.Options{
+		TrustedRoots: map[string][]*trust.AMDRootCerts{
+			test.GetProductLine(): {root},
+		},
+		Product: abi.DefaultSevProduct(),
+	}
+
+	return
+}
+
+func TestWrapSecrets(t *testing.T) {
+	pub, key := testBrokerKey(t)
+	guest := testGuestKey(t)
+
+	res, err := WrapSecrets(key, guest.PublicKey().Bytes(), testSecrets)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := UnwrapSecrets(guest, pub, res)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != len(testSecrets) {
+		t.Fatalf("got %d secrets, want %d", len(secrets), len(testSecrets))
+	}
+
+	for name, val := range testSecrets {
+		if !bytes.Equal(secrets[name], val) {
+			t.Errorf("%s: got %q, want %q", name, secrets[name], val)
+		}
+	}
+}
+
+func TestWrapSecretsInvalid(t *testing.T) {
+	_, key := testBrokerKey(t)
+	guest := testGuestKey(t)
+
+	if _, err := WrapSecrets(nil, guest.PublicKey().Bytes(), testSecrets); err == nil {
+		t.Error("expected error on missing broker key")
+	}
+
+	if _, err := WrapSecrets(key, []byte("invalid"), testSecrets); err == nil {
+		t.Error("expected error on invalid guest key")
+	}
+}
+
+func TestUnwrapSecretsTampered(t *testing.T) {
+	pub, key := testBrokerKey(t)
+	other, _ := testBrokerKey(t)
+	guest := testGuestKey(t)
+
+	for _, tt := range []struct {
+		name   string
+		broker ed25519.PublicKey
+		guest  *ecdh.PrivateKey
+		tamper func(res *SecretResponse)
+	}{
+		{"wrong broker key", other, guest, nil},
+		{"invalid broker key", pub[:16], guest, nil},
+		{"wrong guest key", pub, testGuestKey(t), nil},
+		{"public key", pub, guest, func(res *SecretResponse) { res.PublicKey[0] ^= 0xff }},
+		{"nonce", pub, guest, func(res *SecretResponse) { res.Nonce[0] ^= 0xff }},
+		{"ciphertext", pub, guest, func(res *SecretResponse) { res.Ciphertext[0] ^= 0xff }},
+		{"signature", pub, guest, func(res *SecretResponse) { res.Signature[0] ^= 0xff }},
+		{"missing signature", pub, guest, func(res *SecretResponse) { res.Signature = nil }},
+		{"resigned", pub, guest, func(res *SecretResponse) {
+			// a response re-wrapped by an attacker controlled key
+			_, k := testBrokerKey(t)
+			r, _ := WrapSecrets(k, guest.PublicKey().Bytes(), testSecrets)
+			*res = *r
+		}},
+	} {
+		res, err := WrapSecrets(key, guest.PublicKey().Bytes(), testSecrets)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.tamper != nil {
+			tt.tamper(res)
+		}
+
+		if _, err = UnwrapSecrets(tt.guest, tt.broker, res); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	if _, err := UnwrapSecrets(guest, pub, nil); err == nil {
+		t.Error("expected error on missing response")
+	}
+}
+
+func TestBrokerRelease(t *testing.T) {
+	pub, key := testBrokerKey(t)
+	guest := testGuestKey(t)
+	measurement := bytes.Repeat([]byte{0xaa}, 48)
+
+	for _, tt := range []struct {
+		name         string
+		measurements [][]byte
+		data         []byte
+		valid        bool
+	}{
+		{"allowed", [][]byte{measurement}, SecretReportData(guest.PublicKey().Bytes()), true},
+		{"any measurement", nil, SecretReportData(guest.PublicKey().Bytes()), true},
+		{"wrong measurement", [][]byte{make([]byte, 48)}, SecretReportData(guest.PublicKey().Bytes()), false},
+		{"wrong report data", [][]byte{measurement}, SecretReportData([]byte("other key")), false},
+	} {
+		raw, certs, verifyOptions := testReport(t, measurement, tt.data)
+
+		b := &Broker{
+			Options: &Options{
+				Verify:       verifyOptions,
+				Measurements: tt.measurements,
+				// test reports set the debug policy bit
+				AllowDebug: true,
+			},
+			Key: key,
+			Secrets: func(*Result) (map[string][]byte, error) {
+				return testSecrets, nil
+			},
+		}
+
+		req := &SecretRequest{
+			PublicKey:    guest.PublicKey().Bytes(),
+			Report:       raw,
+			Certificates: certs,
+		}
+
+		res, err := b.Release(req)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+
+		if !tt.valid {
+			continue
+		}
+
+		secrets, err := UnwrapSecrets(guest, pub, res)
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if len(secrets) != len(testSecrets) {
+			t.Errorf("%s: got %d secrets, want %d", tt.name, len(secrets), len(testSecrets))
+		}
+	}
+}
+
+func TestBrokerReleaseInvalid(t *testing.T) {
+	_, key := testBrokerKey(t)
+	guest := testGuestKey(t)
+	raw, certs, verifyOptions := testReport(t, nil, SecretReportData(guest.PublicKey().Bytes()))
+
+	req := &SecretRequest{
+		PublicKey:    guest.PublicKey().Bytes(),
+		Report:       raw,
+		Certificates: certs,
+	}
+
+	secrets := func(*Result) (map[string][]byte, error) {
+		return testSecrets, nil
+	}
+
+	for _, tt := range []struct {
+		name string
+		b    *Broker
+	}{
+		{"no secrets", &Broker{Options: &Options{Verify: verifyOptions, AllowDebug: true}, Key: key}},
+		{"no key", &Broker{Options: &Options{Verify: verifyOptions, AllowDebug: true}, Secrets: secrets}},
+		{"debug", &Broker{Options: &Options{Verify: verifyOptions}, Key: key, Secrets: secrets}},
+	} {
+		if _, err := tt.b.Release(req); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// Endorsement key certificate subject common names.
+const (
+	vcekCommonName = "SEV-VCEK"
+	vlekCommonName = "SEV-VLEK"
+)
+
+// ParseChain parses a bundle of PEM encoded certificates (or a single DER
+// encoded certificate) into an endorsement key certificate chain, for
+// offline verification.
+//
+// The bundle can contain, in any order, the VCEK or VLEK certificate and
+// optionally the ASK (or ASVK) and ARK certificates, when missing the ASK and
+// ARK certificates embedded in the go-sev-guest verify package are used.
+func ParseChain(buf []byte) (chain *spb.CertificateChain, err error) {
+	var certs [][]byte
+
+	for {
+		var block *pem.Block
+
+		if block, buf = pem.Decode(buf); block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certs = append(certs, block.Bytes)
+	}
+
+	if len(certs) == 0 && len(buf) > 0 {
+		certs = append(certs, buf)
+	}
+
+	chain = &spb.CertificateChain{}
+
+	for _, der := range certs {
+		if err = chainAdd(chain, der); err != nil {
+			return nil, err
+		}
+	}
+
+	if chain.VcekCert == nil && chain.VlekCert == nil {
+		return nil, errors.New("missing endorsement key certificate")
+	}
+
+	return
+}
+
+// ParseCertTable parses the certificate table returned by the hypervisor on
+// SNP Extended Guest Requests into an endorsement key certificate chain, for
+// offline verification.
+func ParseCertTable(buf []byte) (chain *spb.CertificateChain, err error) {
+	table := &abi.CertTable{}
+
+	if err = table.Unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("could not parse certificate table, %v", err)
+	}
+
+	chain = table.Proto()
+
+	if chain.VcekCert == nil && chain.VlekCert == nil {
+		return nil, errors.New("missing endorsement key certificate")
+	}
+
+	return
+}
+
+func chainAdd(chain *spb.CertificateChain, der []byte) (err error) {
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		return fmt.Errorf("could not parse certificate, %v", err)
+	}
+
+	selfSigned := bytes.Equal(cert.RawIssuer, cert.RawSubject)
+
+	switch {
+	case cert.IsCA && selfSigned:
+		chain.ArkCert = der
+	case cert.IsCA:
+		chain.AskCert = der
+	case cert.Subject.CommonName == vcekCommonName:
+		chain.VcekCert = der
+	case cert.Subject.CommonName == vlekCommonName:
+		chain.VlekCert = der
+	default:
+		return fmt.Errorf("unexpected certificate (%s)", cert.Subject)
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// EvidencePath is the unikernel HTTP endpoint serving attestation evidence.
+const EvidencePath = "/attestation"
+
+// maxEvidenceSize is the maximum evidence response size.
+const maxEvidenceSize = 64 * 1024
+
+// Evidence represents the attestation evidence served by the unikernel
+// [EvidencePath] endpoint.
+type Evidence struct {
+	// Nonce is the verifier supplied nonce, embedded as REPORT_DATA.
+	Nonce []byte `json:"nonce"`
+	// Report is the raw attestation report.
+	Report []byte `json:"report"`
+	// Certificates is the certificate table supplied by the hypervisor,
+	// empty if not available (see [ParseCertTable]).
+	Certificates []byte `json:"certificates,omitempty"`
+}
+
+// VerifyEvidence verifies attestation evidence against the argument non-empty
+// nonce, when opts.Chain is not set the hypervisor supplied certificates, if
+// present, are used for offline verification.
+func VerifyEvidence(ev *Evidence, nonce []byte, opts *Options) (res *Result, err error) {
+	if ev == nil {
+		return nil, fmt.Errorf("missing evidence")
+	}
+
+	if len(nonce) == 0 {
+		return nil, fmt.Errorf("missing nonce")
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.Chain == nil && len(ev.Certificates) > 0 {
+		o := *opts
+
+		if o.Chain, err = ParseCertTable(ev.Certificates); err != nil {
+			return
+		}
+
+		opts = &o
+	}
+
+	return Verify(ev.Report, nonce, opts)
+}
+
+// Challenge requests attestation evidence, bound to the argument nonce, from
+// the unikernel base URL (e.g. `https://10.0.0.1`) and verifies it.
+func Challenge(client *http.Client, base string, nonce []byte, opts *Options) (res *Result, err error) {
+	if len(nonce) == 0 {
+		return nil, fmt.Errorf("missing nonce")
+	}
+
+	if len(nonce) > ReportDataSize {
+		return nil, fmt.Errorf("invalid nonce size (%d > %d)", len(nonce), ReportDataSize)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u, err := url.JoinPath(base, EvidencePath)
+
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Get(u + "?nonce=" + hex.EncodeToString(nonce))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not request evidence, %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not request evidence, %s", resp.Status)
+	}
+
+	ev := &Evidence{}
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxEvidenceSize))
+
+	if err = dec.Decode(ev); err != nil {
+		return nil, fmt.Errorf("could not parse evidence, %v", err)
+	}
+
+	return VerifyEvidence(ev, nonce, opts)
+}
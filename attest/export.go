@@ -0,0 +1,50 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReportJSON converts a raw attestation report to its JSON representation,
+// which includes every report field following the go-sev-guest
+// `sevsnp.Report` protobuf message (byte fields are base64 encoded).
+func ReportJSON(raw []byte) (buf []byte, err error) {
+	report, err := abi.ReportToProto(raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse report, %v", err)
+	}
+
+	opts := protojson.MarshalOptions{
+		Multiline:       true,
+		EmitUnpopulated: true,
+	}
+
+	return opts.Marshal(report)
+}
+
+// Attestation converts a raw attestation report, and optional endorsement
+// key certificate chain, to a binary encoded go-sev-guest
+// `sevsnp.Attestation` protobuf message, as consumed by go-sev-guest tooling
+// (e.g. `check -inform proto`).
+func Attestation(raw []byte, chain *spb.CertificateChain) (buf []byte, err error) {
+	report, err := abi.ReportToProto(raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse report, %v", err)
+	}
+
+	return proto.Marshal(&spb.Attestation{
+		Report:           report,
+		CertificateChain: chain,
+	})
+}
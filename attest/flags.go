@@ -0,0 +1,274 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/google/go-sev-guest/abi"
+)
+
+// field represents a named bit within a bitmap, decoded as boolean structure
+// field.
+type field[T any] struct {
+	bit  uint
+	name string
+	get  func(*T) *bool
+}
+
+// decodeFields sets the structure fields of all bits in the argument table,
+// bits not in the table are returned.
+func decodeFields[T any](v *T, val uint64, table []field[T]) (unknown uint64) {
+	unknown = val
+
+	for _, f := range table {
+		*f.get(v) = val&(1<<f.bit) != 0
+		unknown &^= 1 << f.bit
+	}
+
+	return
+}
+
+// names returns the names of all set structure fields, followed by unknown
+// bits reported as "bit<n>".
+func names[T any](v *T, unknown uint64, table []field[T]) (n []string) {
+	for _, f := range table {
+		if *f.get(v) {
+			n = append(n, f.name)
+		}
+	}
+
+	for ; unknown != 0; unknown &= unknown - 1 {
+		n = append(n, fmt.Sprintf("bit%d", bits.TrailingZeros64(unknown)))
+	}
+
+	return
+}
+
+// fieldNames returns the names of all fields in the argument table.
+func fieldNames[T any](table []field[T]) (n []string) {
+	for _, f := range table {
+		n = append(n, f.name)
+	}
+
+	return
+}
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 10: Guest Policy Structure.
+const policyPageSwapDisable = 1 << 25
+
+// Policy represents a decoded guest policy.
+type Policy struct {
+	abi.SnpPolicy
+
+	// PageSwapDisable is true if guest page swapping is disabled, this bit
+	// is not decoded by [abi.ParseSnpPolicy].
+	PageSwapDisable bool
+}
+
+var policyFields = []field[Policy]{
+	{16, "SMT", func(p *Policy) *bool { return &p.SMT }},
+	{18, "MIGRATE_MA", func(p *Policy) *bool { return &p.MigrateMA }},
+	{19, "DEBUG", func(p *Policy) *bool { return &p.Debug }},
+	{20, "SINGLE_SOCKET", func(p *Policy) *bool { return &p.SingleSocket }},
+	{21, "CXL_ALLOW", func(p *Policy) *bool { return &p.CXLAllowed }},
+	{22, "MEM_AES_256_XTS", func(p *Policy) *bool { return &p.MemAES256XTS }},
+	{23, "RAPL_DIS", func(p *Policy) *bool { return &p.RAPLDis }},
+	{24, "CIPHERTEXT_HIDING_DRAM", func(p *Policy) *bool { return &p.CipherTextHidingDRAM }},
+	{25, "PAGE_SWAP_DISABLE", func(p *Policy) *bool { return &p.PageSwapDisable }},
+}
+
+// DecodePolicy decodes the argument guest policy, an error is returned if
+// reserved bits are not set as required by the firmware ABI.
+func DecodePolicy(val uint64) (p *Policy, err error) {
+	p = &Policy{
+		PageSwapDisable: val&policyPageSwapDisable != 0,
+	}
+
+	if p.SnpPolicy, err = abi.ParseSnpPolicy(val &^ policyPageSwapDisable); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Flags returns the names of set policy bits (e.g. "SMT", "DEBUG").
+func (p *Policy) Flags() []string {
+	return names(p, 0, policyFields)
+}
+
+// PolicyFlags returns the names of all decoded policy bits.
+func PolicyFlags() []string {
+	return fieldNames(policyFields)
+}
+
+// PlatformInfo represents a decoded PLATFORM_INFO field.
+type PlatformInfo struct {
+	abi.SnpPlatformInfo
+}
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 23: ATTESTATION_REPORT Structure (PLATFORM_INFO).
+var platformInfoFields = []field[PlatformInfo]{
+	{0, "SMT_EN", func(i *PlatformInfo) *bool { return &i.SMTEnabled }},
+	{1, "TSME_EN", func(i *PlatformInfo) *bool { return &i.TSMEEnabled }},
+	{2, "ECC_EN", func(i *PlatformInfo) *bool { return &i.ECCEnabled }},
+	{3, "RAPL_DIS", func(i *PlatformInfo) *bool { return &i.RAPLDisabled }},
+	{4, "CIPHERTEXT_HIDING_DRAM_EN", func(i *PlatformInfo) *bool { return &i.CiphertextHidingDRAMEnabled }},
+	{5, "ALIAS_CHECK_COMPLETE", func(i *PlatformInfo) *bool { return &i.AliasCheckComplete }},
+}
+
+// DecodePlatformInfo decodes the argument PLATFORM_INFO value, an error is
+// returned on unknown bits.
+func DecodePlatformInfo(val uint64) (i *PlatformInfo, err error) {
+	i = &PlatformInfo{}
+
+	if i.SnpPlatformInfo, err = abi.ParseSnpPlatformInfo(val); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Flags returns the names of set PLATFORM_INFO bits (e.g. "SMT_EN",
+// "TSME_EN").
+func (i *PlatformInfo) Flags() []string {
+	return names(i, 0, platformInfoFields)
+}
+
+// PlatformInfoFlags returns the names of all decoded PLATFORM_INFO bits.
+func PlatformInfoFlags() []string {
+	return fieldNames(platformInfoFields)
+}
+
+// Mitigations represents a decoded LAUNCH_MIT_VECTOR or CURRENT_MIT_VECTOR.
+//
+// Mitigation vector bits are assigned along with AMD security bulletins,
+// rather than by the firmware ABI, therefore they are only reported by
+// position.
+type Mitigations struct {
+	// Vector is the mitigation vector value.
+	Vector uint64
+}
+
+// DecodeMitigations decodes the argument mitigation vector.
+func DecodeMitigations(val uint64) *Mitigations {
+	return &Mitigations{
+		Vector: val,
+	}
+}
+
+// Has returns whether the argument mitigation bit is set.
+func (m *Mitigations) Has(bit uint) bool {
+	return bit < 64 && m.Vector&(1<<bit) != 0
+}
+
+// Flags returns the positions of set mitigation bits (e.g. "bit0").
+func (m *Mitigations) Flags() []string {
+	return names(m, m.Vector, nil)
+}
+
+// SEVFeatures represents a decoded SEV_FEATURES field, the same layout
+// applies to SEV_STATUS MSR bits from bit 2 onwards.
+type SEVFeatures struct {
+	SNPActive           bool
+	VTOM                bool
+	ReflectVC           bool
+	RestrictedInjection bool
+	AlternateInjection  bool
+	DebugSwap           bool
+	PreventHostIBS      bool
+	BTBIsolation        bool
+	VmplSSS             bool
+	SecureTSC           bool
+	VmgexitParameter    bool
+	IbsVirtualization   bool
+	VmsaRegProt         bool
+	SmtProtection       bool
+	SecureAVIC          bool
+
+	// Unknown holds set bits not decoded.
+	Unknown uint64
+}
+
+// AMD64 Architecture Programmer's Manual, Volume 2
+// Table 15-38: SEV_FEATURES Field of VMSA.
+var sevFeatureFields = []field[SEVFeatures]{
+	{0, "SNPActive", func(f *SEVFeatures) *bool { return &f.SNPActive }},
+	{1, "vTOM", func(f *SEVFeatures) *bool { return &f.VTOM }},
+	{2, "ReflectVC", func(f *SEVFeatures) *bool { return &f.ReflectVC }},
+	{3, "RestrictedInjection", func(f *SEVFeatures) *bool { return &f.RestrictedInjection }},
+	{4, "AlternateInjection", func(f *SEVFeatures) *bool { return &f.AlternateInjection }},
+	{5, "DebugSwap", func(f *SEVFeatures) *bool { return &f.DebugSwap }},
+	{6, "PreventHostIBS", func(f *SEVFeatures) *bool { return &f.PreventHostIBS }},
+	{7, "BTBIsolation", func(f *SEVFeatures) *bool { return &f.BTBIsolation }},
+	{8, "VmplSSS", func(f *SEVFeatures) *bool { return &f.VmplSSS }},
+	{9, "SecureTSC", func(f *SEVFeatures) *bool { return &f.SecureTSC }},
+	{10, "VmgexitParameter", func(f *SEVFeatures) *bool { return &f.VmgexitParameter }},
+	{12, "IbsVirtualization", func(f *SEVFeatures) *bool { return &f.IbsVirtualization }},
+	{14, "VmsaRegProt", func(f *SEVFeatures) *bool { return &f.VmsaRegProt }},
+	{15, "SmtProtection", func(f *SEVFeatures) *bool { return &f.SmtProtection }},
+	{16, "SecureAVIC", func(f *SEVFeatures) *bool { return &f.SecureAVIC }},
+}
+
+// DecodeSEVFeatures decodes the argument SEV_FEATURES value.
+func DecodeSEVFeatures(val uint64) (f *SEVFeatures) {
+	f = &SEVFeatures{}
+	f.Unknown = decodeFields(f, val, sevFeatureFields)
+
+	return
+}
+
+// Flags returns the names of set SEV_FEATURES bits (e.g. "SNPActive",
+// "SecureTSC").
+func (f *SEVFeatures) Flags() []string {
+	return names(f, f.Unknown, sevFeatureFields)
+}
+
+// HypervisorFeatures represents decoded GHCB hypervisor feature bits.
+type HypervisorFeatures struct {
+	SNP                      bool
+	APCreation               bool
+	RestrictedInjection      bool
+	RestrictedInjectionTimer bool
+	APICIDList               bool
+	MultiVMPL                bool
+	PSC                      bool
+	TIO                      bool
+
+	// Unknown holds set bits not decoded.
+	Unknown uint64
+}
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 4.1.10 Hypervisor Feature Support.
+var hypervisorFeatureFields = []field[HypervisorFeatures]{
+	{0, "SNP", func(f *HypervisorFeatures) *bool { return &f.SNP }},
+	{1, "AP_CREATION", func(f *HypervisorFeatures) *bool { return &f.APCreation }},
+	{2, "RESTRICTED_INJECTION", func(f *HypervisorFeatures) *bool { return &f.RestrictedInjection }},
+	{3, "RESTRICTED_INJECTION_TIMER", func(f *HypervisorFeatures) *bool { return &f.RestrictedInjectionTimer }},
+	{4, "APIC_ID_LIST", func(f *HypervisorFeatures) *bool { return &f.APICIDList }},
+	{5, "MULTI_VMPL", func(f *HypervisorFeatures) *bool { return &f.MultiVMPL }},
+	{6, "PSC", func(f *HypervisorFeatures) *bool { return &f.PSC }},
+	{7, "TIO", func(f *HypervisorFeatures) *bool { return &f.TIO }},
+}
+
+// DecodeHypervisorFeatures decodes the argument GHCB hypervisor feature
+// bitmap.
+func DecodeHypervisorFeatures(val uint64) (f *HypervisorFeatures) {
+	f = &HypervisorFeatures{}
+	f.Unknown = decodeFields(f, val, hypervisorFeatureFields)
+
+	return
+}
+
+// Flags returns the names of set hypervisor feature bits (e.g. "SNP",
+// "AP_CREATION").
+func (f *HypervisorFeatures) Flags() []string {
+	return names(f, f.Unknown, hypervisorFeatureFields)
+}
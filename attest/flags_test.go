@@ -0,0 +1,164 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDecodePolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		val   uint64
+		flags []string
+		valid bool
+	}{
+		{"default", 0x30000, []string{"SMT"}, true},
+		{"ABI version", 0x30102, []string{"SMT"}, true},
+		{"debug", 0xa0000, []string{"DEBUG"}, true},
+		{"all", 0x3ff0000, []string{"SMT", "MIGRATE_MA", "DEBUG", "SINGLE_SOCKET", "CXL_ALLOW", "MEM_AES_256_XTS", "RAPL_DIS", "CIPHERTEXT_HIDING_DRAM", "PAGE_SWAP_DISABLE"}, true},
+		{"page swap disable", 0x2020000, []string{"PAGE_SWAP_DISABLE"}, true},
+		{"reserved bit 17 clear", 0x10000, nil, false},
+		{"reserved high bit", 1<<17 | 1<<40, nil, false},
+	} {
+		p, err := DecodePolicy(tt.val)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+
+		if !tt.valid {
+			continue
+		}
+
+		if got := p.Flags(); !slices.Equal(got, tt.flags) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.flags)
+		}
+	}
+
+	p, err := DecodePolicy(0x30102)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.ABIMajor != 1 || p.ABIMinor != 2 {
+		t.Errorf("got ABI %d.%d, want 1.2", p.ABIMajor, p.ABIMinor)
+	}
+}
+
+func TestDecodePlatformInfo(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		val   uint64
+		flags []string
+		valid bool
+	}{
+		{"none", 0, nil, true},
+		{"SMT", 0x1, []string{"SMT_EN"}, true},
+		{"all", 0x3f, []string{"SMT_EN", "TSME_EN", "ECC_EN", "RAPL_DIS", "CIPHERTEXT_HIDING_DRAM_EN", "ALIAS_CHECK_COMPLETE"}, true},
+		{"reserved bit", 1 << 40, nil, false},
+		{"reserved bit with flags", 0x1 | 1<<63, nil, false},
+	} {
+		i, err := DecodePlatformInfo(tt.val)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+
+		if !tt.valid {
+			continue
+		}
+
+		if got := i.Flags(); !slices.Equal(got, tt.flags) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.flags)
+		}
+	}
+}
+
+func TestDecodeMitigations(t *testing.T) {
+	for _, tt := range []struct {
+		val   uint64
+		flags []string
+		has   []uint
+	}{
+		{0, nil, nil},
+		{0xb, []string{"bit0", "bit1", "bit3"}, []uint{0, 1, 3}},
+		{1 << 63, []string{"bit63"}, []uint{63}},
+	} {
+		m := DecodeMitigations(tt.val)
+
+		if got := m.Flags(); !slices.Equal(got, tt.flags) {
+			t.Errorf("%#x: got %v, want %v", tt.val, got, tt.flags)
+		}
+
+		for bit := range uint(65) {
+			if got := m.Has(bit); got != slices.Contains(tt.has, bit) {
+				t.Errorf("%#x: bit %d got %v", tt.val, bit, got)
+			}
+		}
+	}
+}
+
+func TestDecodeSEVFeatures(t *testing.T) {
+	for _, tt := range []struct {
+		val     uint64
+		flags   []string
+		unknown uint64
+	}{
+		{0, nil, 0},
+		{0x1, []string{"SNPActive"}, 0},
+		{0x201, []string{"SNPActive", "SecureTSC"}, 0},
+		{0x1d7ff, []string{"SNPActive", "vTOM", "ReflectVC", "RestrictedInjection", "AlternateInjection", "DebugSwap", "PreventHostIBS", "BTBIsolation", "VmplSSS", "SecureTSC", "VmgexitParameter", "IbsVirtualization", "VmsaRegProt", "SmtProtection", "SecureAVIC"}, 0},
+		// reserved bits 11 and 13
+		{0x2801, []string{"SNPActive", "bit11", "bit13"}, 0x2800},
+		{1 << 62, []string{"bit62"}, 1 << 62},
+	} {
+		f := DecodeSEVFeatures(tt.val)
+
+		if f.Unknown != tt.unknown {
+			t.Errorf("%#x: got unknown %#x, want %#x", tt.val, f.Unknown, tt.unknown)
+		}
+
+		if got := f.Flags(); !slices.Equal(got, tt.flags) {
+			t.Errorf("%#x: got %v, want %v", tt.val, got, tt.flags)
+		}
+	}
+
+	if f := DecodeSEVFeatures(0x204); f.SNPActive || !f.ReflectVC || !f.SecureTSC {
+		t.Errorf("got %+v", f)
+	}
+}
+
+func TestDecodeHypervisorFeatures(t *testing.T) {
+	for _, tt := range []struct {
+		val     uint64
+		flags   []string
+		unknown uint64
+	}{
+		{0, nil, 0},
+		{0x3, []string{"SNP", "AP_CREATION"}, 0},
+		{0xff, []string{"SNP", "AP_CREATION", "RESTRICTED_INJECTION", "RESTRICTED_INJECTION_TIMER", "APIC_ID_LIST", "MULTI_VMPL", "PSC", "TIO"}, 0},
+		{0x101, []string{"SNP", "bit8"}, 0x100},
+	} {
+		f := DecodeHypervisorFeatures(tt.val)
+
+		if f.Unknown != tt.unknown {
+			t.Errorf("%#x: got unknown %#x, want %#x", tt.val, f.Unknown, tt.unknown)
+		}
+
+		if got := f.Flags(); !slices.Equal(got, tt.flags) {
+			t.Errorf("%#x: got %v, want %v", tt.val, got, tt.flags)
+		}
+	}
+
+	if f := DecodeHypervisorFeatures(0x42); f.SNP || !f.APCreation || !f.PSC {
+		t.Errorf("got %+v", f)
+	}
+}
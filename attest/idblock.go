@@ -0,0 +1,176 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 75: ID_BLOCK Structure.
+const (
+	IDBlockVersion = 1
+	idBlockSize    = 96
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 76: ID_AUTH_INFO Structure.
+const (
+	idAuthSize = 0x1000
+
+	idAuthIDKeyAlgo     = 0x000
+	idAuthAuthorKeyAlgo = 0x004
+	idAuthIDBlockSig    = 0x040
+	idAuthIDKey         = 0x240
+	idAuthIDKeySig      = 0x680
+	idAuthAuthorKey     = 0x880
+
+	// ECDSA P-384 with SHA-384
+	algoECDSAP384SHA384 = 1
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Chapter 10: Cryptographic Algorithms (ECDSA signature and public key
+// formats).
+const (
+	ecdsaCurveP384  = 2
+	ecdsaScalarSize = 72
+	ecdsaSigSize    = 0x200
+	ecdsaPubKeySize = 0x404
+)
+
+// IDBlock represents an AMD SEV-SNP ID block, which the firmware checks
+// against the launch digest and policy when finishing a launch.
+type IDBlock struct {
+	// LD is the expected launch digest (see [LaunchDigest]).
+	LD [48]byte
+	// FamilyID is the guest family identifier.
+	FamilyID [16]byte
+	// ImageID is the guest image identifier.
+	ImageID [16]byte
+	// Version is the ID block format version (see [IDBlockVersion]).
+	Version uint32
+	// GuestSVN is the guest security version number.
+	GuestSVN uint32
+	// Policy is the guest policy.
+	Policy uint64
+}
+
+// Bytes converts the descriptor structure to byte array format.
+func (b *IDBlock) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, b)
+	return buf.Bytes()
+}
+
+// littleEndian returns the argument integer in zero padded little-endian
+// format.
+func littleEndian(n *big.Int) []byte {
+	buf := n.FillBytes(make([]byte, ecdsaScalarSize))
+
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+
+	return buf
+}
+
+// publicKey returns the firmware ECDSA P-384 public key format.
+func publicKey(pub *ecdsa.PublicKey) (buf []byte, err error) {
+	if pub.Curve != elliptic.P384() {
+		return nil, errors.New("invalid key, ECDSA P-384 required")
+	}
+
+	buf = make([]byte, ecdsaPubKeySize)
+
+	binary.LittleEndian.PutUint32(buf[0:], ecdsaCurveP384)
+	copy(buf[0x04:], littleEndian(pub.X))
+	copy(buf[0x4c:], littleEndian(pub.Y))
+
+	return
+}
+
+// sign returns the firmware ECDSA P-384 signature format for the SHA-384
+// digest of the argument data.
+func sign(key *ecdsa.PrivateKey, data []byte) (buf []byte, err error) {
+	sum := sha512.Sum384(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+
+	if err != nil {
+		return
+	}
+
+	buf = make([]byte, ecdsaSigSize)
+
+	copy(buf[0x00:], littleEndian(r))
+	copy(buf[0x48:], littleEndian(s))
+
+	return
+}
+
+// KeyDigest returns the SHA-384 digest of an ID or author key, as reported in
+// the attestation report ID_KEY_DIGEST and AUTHOR_KEY_DIGEST fields.
+func KeyDigest(pub *ecdsa.PublicKey) (digest []byte, err error) {
+	buf, err := publicKey(pub)
+
+	if err != nil {
+		return
+	}
+
+	sum := sha512.Sum384(buf)
+
+	return sum[:], nil
+}
+
+// Sign returns the ID authentication information structure for the ID block,
+// signed with the ID key. The ID key is in turn signed with the author key,
+// if not nil.
+func (b *IDBlock) Sign(idKey *ecdsa.PrivateKey, authorKey *ecdsa.PrivateKey) (auth []byte, err error) {
+	auth = make([]byte, idAuthSize)
+
+	idPub, err := publicKey(&idKey.PublicKey)
+
+	if err != nil {
+		return
+	}
+
+	sig, err := sign(idKey, b.Bytes())
+
+	if err != nil {
+		return
+	}
+
+	binary.LittleEndian.PutUint32(auth[idAuthIDKeyAlgo:], algoECDSAP384SHA384)
+	copy(auth[idAuthIDBlockSig:], sig)
+	copy(auth[idAuthIDKey:], idPub)
+
+	if authorKey == nil {
+		return
+	}
+
+	authorPub, err := publicKey(&authorKey.PublicKey)
+
+	if err != nil {
+		return
+	}
+
+	if sig, err = sign(authorKey, idPub); err != nil {
+		return
+	}
+
+	binary.LittleEndian.PutUint32(auth[idAuthAuthorKeyAlgo:], algoECDSAP384SHA384)
+	copy(auth[idAuthIDKeySig:], sig)
+	copy(auth[idAuthAuthorKey:], authorPub)
+
+	return
+}
@@ -0,0 +1,398 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 67: PAGE_TYPE Field Encodings.
+const (
+	pageTypeNormal     = 0x1
+	pageTypeVMSA       = 0x2
+	pageTypeZero       = 0x3
+	pageTypeUnmeasured = 0x4
+	pageTypeSecrets    = 0x5
+	pageTypeCPUID      = 0x6
+)
+
+const (
+	pageSize = 4096
+
+	// PAGE_INFO structure size
+	pageInfoSize = 0x70
+	// VMSA pages are measured at a fixed GPA
+	vmsaGPA = 0xfffffffff000
+	// BSP reset vector
+	bspEIP = 0xfffffff0
+)
+
+// QEMU kernel hashes table GUIDs
+// (QEMU target/i386/sev.c, edk2 OvmfPkg/AmdSev/BlobVerifierLibSevHashes).
+var (
+	hashTableHeaderGUID = guid("9438d606-4f22-4cc9-b479-a793d411fd21")
+	hashKernelGUID      = guid("4de79437-abd2-427f-b835-d5b172d2045b")
+	hashInitrdGUID      = guid("44baf731-3a2f-4bd7-9af1-41e29169781d")
+	hashCmdlineGUID     = guid("97d02dd8-bd20-4c94-aa78-e7714d36ab2a")
+)
+
+// segment represents a VMSA segment register.
+type segment struct {
+	Selector uint16
+	Attrib   uint16
+	Limit    uint32
+	Base     uint64
+}
+
+// vmsa represents the leading portion of an AMD SEV-SNP Virtual Machine Save
+// Area page, up to the x87 state populated by QEMU/KVM (AMD64 Architecture
+// Programmer’s Manual, Volume 2 - Table B-4).
+//
+// Its layout and field names mirror the TamaGo kvm/sev VMSA type, which is
+// not used directly as that package depends on the TamaGo runtime.
+type vmsa struct {
+	ES   segment
+	CS   segment
+	SS   segment
+	DS   segment
+	FS   segment
+	GS   segment
+	GDTR segment
+	LDTR segment
+	IDTR segment
+	TR   segment
+
+	PL0SSP uint64
+	PL1SSP uint64
+	PL2SSP uint64
+	PL3SSP uint64
+	U_CET  uint64
+	_      [2]byte
+
+	VMPL uint8
+	CPL  uint8
+	_    [4]byte
+
+	EFER uint64
+	_    [104]byte
+
+	XSS         uint64
+	CR4         uint64
+	CR3         uint64
+	CR0         uint64
+	DR7         uint64
+	DR6         uint64
+	RFLAGS      uint64
+	RIP         uint64
+	DR0         uint64
+	DR1         uint64
+	DR2         uint64
+	DR3         uint64
+	DR0AddrMask uint64
+	DR1AddrMask uint64
+	DR2AddrMask uint64
+	DR3AddrMask uint64
+	_           [24]byte
+
+	RSP          uint64
+	S_CET        uint64
+	SSP          uint64
+	ISST_ADDR    uint64
+	RAX          uint64
+	STAR         uint64
+	LSTAR        uint64
+	CSTAR        uint64
+	SFMASK       uint64
+	KernelGsBase uint64
+	SYSENTER_CS  uint64
+	SYSENTER_ESP uint64
+	SYSENTER_EIP uint64
+	CR2          uint64
+	_            [32]byte
+
+	G_PAT        uint64
+	DBGCTL       uint64
+	BR_FROM      uint64
+	BR_TO        uint64
+	LASTEXCPFROM uint64
+	LASTEXCPTO   uint64
+	_            [80]byte
+
+	PKRU    uint32
+	TSC_AUX uint32
+	_       [24]byte
+
+	RCX uint64
+	RDX uint64
+	RBX uint64
+	_   [8]byte
+	RBP uint64
+	RSI uint64
+	RDI uint64
+	R8  uint64
+	R9  uint64
+	R10 uint64
+	R11 uint64
+	R12 uint64
+	R13 uint64
+	R14 uint64
+	R15 uint64
+	_   [16]byte
+
+	GUEST_EXITINFO1   uint64
+	GUEST_EXITINFO2   uint64
+	GUEST_EXITINTINFO uint64
+	GUEST_NRIP        uint64
+	SEV_FEATURES      uint64
+	VINTR_CTRL        uint64
+	GUEST_EXITCODE    uint64
+	VIRTUAL_TOM       uint64
+	TLB_ID            uint64
+	PCPU_ID           uint64
+	EVENTINJ          uint64
+	XCR0              uint64
+	_                 [16]byte
+
+	X87_DP  uint64
+	MXCSR   uint32
+	X87_FTW uint16
+	X87_FSW uint16
+	X87_FCW uint16
+}
+
+// LaunchOptions represents the guest launch parameters covered by the AMD
+// SEV-SNP launch digest under QEMU/KVM.
+type LaunchOptions struct {
+	// OVMF is the firmware image.
+	OVMF []byte
+
+	// Kernel is the image passed with QEMU `-kernel`, it is measured in
+	// the kernel hashes table (`kernel-hashes=on`) when not nil.
+	Kernel []byte
+	// Initrd is the image passed with QEMU `-initrd`, if any.
+	Initrd []byte
+	// Append is the command line passed with QEMU `-append`, if any.
+	Append string
+
+	// VCPUs is the number of vCPUs.
+	VCPUs int
+	// VCPUSignature is the vCPU CPUID(1).EAX signature (see [CPUSignature]).
+	VCPUSignature uint32
+	// Features is the VMSA SEV_FEATURES value.
+	Features uint64
+}
+
+// CPUSignature returns the CPUID(1).EAX signature for the argument CPU
+// family, model and stepping.
+func CPUSignature(family, model, stepping uint32) uint32 {
+	var familyHigh uint32
+
+	if family > 0xf {
+		familyHigh = (family - 0xf) & 0xff
+		family = 0xf
+	}
+
+	return familyHigh<<20 | (model>>4&0xf)<<16 | family<<8 | (model&0xf)<<4 | stepping&0xf
+}
+
+// launchDigest represents the SNP_LAUNCH_UPDATE digest state.
+type launchDigest struct {
+	ld [sha512.Size384]byte
+}
+
+// update extends the launch digest with a PAGE_INFO structure (SEV Secure
+// Nested Paging Firmware ABI Specification - 8.17.2 SNP_LAUNCH_UPDATE).
+func (d *launchDigest) update(pageType uint8, gpa uint64, contents []byte) {
+	info := make([]byte, pageInfoSize)
+
+	copy(info[0:], d.ld[:])
+	copy(info[48:], contents)
+	binary.LittleEndian.PutUint16(info[96:], pageInfoSize)
+	info[98] = pageType
+	// IMI_PAGE and VMPL permissions are zero
+	binary.LittleEndian.PutUint64(info[104:], gpa)
+
+	d.ld = sha512.Sum384(info)
+}
+
+func (d *launchDigest) normal(gpa uint64, data []byte) {
+	for off := 0; off < len(data); off += pageSize {
+		sum := sha512.Sum384(data[off : off+pageSize])
+		d.update(pageTypeNormal, gpa+uint64(off), sum[:])
+	}
+}
+
+func (d *launchDigest) pages(pageType uint8, gpa uint64, size uint64) {
+	for off := uint64(0); off < size; off += pageSize {
+		d.update(pageType, gpa+off, nil)
+	}
+}
+
+func (d *launchDigest) vmsa(page []byte) {
+	sum := sha512.Sum384(page)
+	d.update(pageTypeVMSA, vmsaGPA, sum[:])
+}
+
+// hashTable returns the page holding the QEMU kernel hashes table at the
+// argument offset.
+func hashTable(opts *LaunchOptions, offset int) (page []byte, err error) {
+	const entrySize = 16 + 2 + sha256.Size
+	const tableSize = 16 + 2 + 3*entrySize
+
+	page = make([]byte, pageSize)
+
+	if offset+tableSize > pageSize {
+		return nil, errors.New("invalid hash table offset")
+	}
+
+	cmdline := sha256.Sum256(append([]byte(opts.Append), 0x00))
+	initrd := sha256.Sum256(opts.Initrd)
+	kernel := sha256.Sum256(opts.Kernel)
+
+	t := page[offset:]
+	copy(t[0:], hashTableHeaderGUID[:])
+	binary.LittleEndian.PutUint16(t[16:], tableSize)
+	t = t[18:]
+
+	for i, e := range []struct {
+		guid [16]byte
+		hash [sha256.Size]byte
+	}{
+		{hashCmdlineGUID, cmdline},
+		{hashInitrdGUID, initrd},
+		{hashKernelGUID, kernel},
+	} {
+		entry := t[i*entrySize:]
+		copy(entry[0:], e.guid[:])
+		binary.LittleEndian.PutUint16(entry[16:], entrySize)
+		copy(entry[18:], e.hash[:])
+	}
+
+	return
+}
+
+// vmsaPage returns the initial VMSA populated by QEMU/KVM for a vCPU starting
+// at the argument reset vector.
+func vmsaPage(eip uint32, opts *LaunchOptions) []byte {
+	data := segment{Attrib: 0x93, Limit: 0xffff}
+
+	v := &vmsa{
+		ES:   data,
+		CS:   segment{Selector: 0xf000, Attrib: 0x9b, Limit: 0xffff, Base: uint64(eip & 0xffff0000)},
+		SS:   data,
+		DS:   data,
+		FS:   data,
+		GS:   data,
+		GDTR: segment{Limit: 0xffff},
+		LDTR: segment{Attrib: 0x82, Limit: 0xffff},
+		IDTR: segment{Limit: 0xffff},
+		TR:   segment{Attrib: 0x8b, Limit: 0xffff},
+
+		EFER:         0x1000, // SVME
+		CR4:          0x40,   // MCE
+		CR0:          0x10,   // ET
+		DR7:          0x400,
+		DR6:          0xffff0ff0,
+		RFLAGS:       0x2,
+		RIP:          uint64(eip & 0xffff),
+		G_PAT:        0x0007040600070406,
+		RDX:          uint64(opts.VCPUSignature),
+		SEV_FEATURES: opts.Features,
+		XCR0:         0x1,
+		MXCSR:        0x1f80,
+		X87_FCW:      0x37f,
+	}
+
+	page := make([]byte, pageSize)
+	binary.Encode(page, binary.LittleEndian, v)
+
+	return page
+}
+
+// LaunchDigest computes the AMD SEV-SNP launch digest, as reported in the
+// attestation report MEASUREMENT field, for a QEMU/KVM guest launched with
+// the argument options.
+func LaunchDigest(opts *LaunchOptions) (digest []byte, err error) {
+	var d launchDigest
+
+	if opts.VCPUs < 1 {
+		return nil, fmt.Errorf("invalid vCPU count (%d)", opts.VCPUs)
+	}
+
+	o, err := parseOVMF(opts.OVMF)
+
+	if err != nil {
+		return
+	}
+
+	d.normal(o.gpa(), o.data)
+
+	hashes := false
+
+	for _, s := range o.sections {
+		gpa := uint64(s.GPA)
+		size := uint64(s.Size)
+
+		switch s.Type {
+		case sectionSNPSecMem, sectionSVSMCAA:
+			d.pages(pageTypeZero, gpa, size)
+		case sectionSNPSecrets:
+			d.pages(pageTypeSecrets, gpa, pageSize)
+		case sectionCPUID:
+			d.pages(pageTypeCPUID, gpa, pageSize)
+		case sectionSNPKernelHashes:
+			if opts.Kernel == nil {
+				d.pages(pageTypeZero, gpa, size)
+				break
+			}
+
+			tableGPA, err := o.hashTableGPA()
+
+			if err != nil {
+				return nil, err
+			}
+
+			page, err := hashTable(opts, int(tableGPA&(pageSize-1)))
+
+			if err != nil {
+				return nil, err
+			}
+
+			d.normal(gpa, page)
+			hashes = true
+		default:
+			return nil, fmt.Errorf("unsupported OVMF section type %#x", s.Type)
+		}
+	}
+
+	if opts.Kernel != nil && !hashes {
+		return nil, errors.New("OVMF does not support kernel hashes")
+	}
+
+	eip, err := o.resetEIP()
+
+	if err != nil && opts.VCPUs > 1 {
+		return
+	}
+
+	bsp := vmsaPage(bspEIP, opts)
+	ap := vmsaPage(eip, opts)
+
+	for i := 0; i < opts.VCPUs; i++ {
+		if i == 0 {
+			d.vmsa(bsp)
+		} else {
+			d.vmsa(ap)
+		}
+	}
+
+	return d.ld[:], nil
+}
@@ -0,0 +1,206 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OVMF GUIDed footer table entries
+// (edk2 OvmfPkg/ResetVector/Ia16/ResetVectorVtf0.asm).
+var (
+	ovmfTableFooterGUID = guid("96b582de-1fb2-45f7-baea-a366c55a082d")
+	sevHashTableGUID    = guid("7255371f-3a3b-4b04-927b-1da6efa8d454")
+	sevESResetBlockGUID = guid("00f771de-1a7e-4fcb-890e-68c77e2fb44e")
+	sevMetadataGUID     = guid("dc886566-984a-4798-a75e-5585a7bf67cc")
+)
+
+// OVMF SEV metadata section types
+// (edk2 OvmfPkg/Include/WorkArea.h).
+const (
+	sectionSNPSecMem       = 1
+	sectionSNPSecrets      = 2
+	sectionCPUID           = 3
+	sectionSVSMCAA         = 4
+	sectionSNPKernelHashes = 0x10
+)
+
+const (
+	fourGB = 0x100000000
+
+	// footer table entry size and GUID
+	ovmfEntryHeaderSize = 2 + 16
+	// footer table offset from the end of the image
+	ovmfFooterOffset = 32
+)
+
+// guid converts a GUID string to its mixed-endian binary representation.
+func guid(s string) (g [16]byte) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+
+	if err != nil || len(b) != len(g) {
+		panic("invalid GUID")
+	}
+
+	copy(g[:], b)
+
+	// first three fields are little-endian
+	g[0], g[1], g[2], g[3] = b[3], b[2], b[1], b[0]
+	g[4], g[5] = b[5], b[4]
+	g[6], g[7] = b[7], b[6]
+
+	return
+}
+
+// ovmfSection represents an OVMF SEV metadata section descriptor.
+type ovmfSection struct {
+	GPA  uint32
+	Size uint32
+	Type uint32
+}
+
+// ovmf represents an OVMF firmware image.
+type ovmf struct {
+	data     []byte
+	table    map[[16]byte][]byte
+	sections []ovmfSection
+}
+
+// parseOVMF parses an OVMF image GUIDed footer table and SEV metadata.
+func parseOVMF(data []byte) (o *ovmf, err error) {
+	o = &ovmf{
+		data:  data,
+		table: make(map[[16]byte][]byte),
+	}
+
+	if len(data)%pageSize != 0 || len(data) > fourGB {
+		return nil, fmt.Errorf("invalid OVMF size (%d)", len(data))
+	}
+
+	if err = o.parseTable(); err != nil {
+		return nil, fmt.Errorf("could not parse footer table, %v", err)
+	}
+
+	if err = o.parseMetadata(); err != nil {
+		return nil, fmt.Errorf("could not parse SEV metadata, %v", err)
+	}
+
+	return
+}
+
+func (o *ovmf) parseTable() error {
+	start := len(o.data) - ovmfFooterOffset - ovmfEntryHeaderSize
+
+	if start < 0 {
+		return errors.New("image too small")
+	}
+
+	footer := o.data[start:]
+	size := int(binary.LittleEndian.Uint16(footer[0:]))
+
+	if !bytes.Equal(footer[2:ovmfEntryHeaderSize], ovmfTableFooterGUID[:]) {
+		return errors.New("footer not found")
+	}
+
+	size -= ovmfEntryHeaderSize
+
+	if size < 0 || size > start {
+		return errors.New("invalid footer size")
+	}
+
+	table := o.data[start-size : start]
+
+	for len(table) >= ovmfEntryHeaderSize {
+		entry := table[len(table)-ovmfEntryHeaderSize:]
+		size := int(binary.LittleEndian.Uint16(entry[0:]))
+
+		if size < ovmfEntryHeaderSize || size > len(table) {
+			return errors.New("invalid entry size")
+		}
+
+		var g [16]byte
+		copy(g[:], entry[2:])
+
+		o.table[g] = table[len(table)-size : len(table)-ovmfEntryHeaderSize]
+		table = table[:len(table)-size]
+	}
+
+	return nil
+}
+
+func (o *ovmf) parseMetadata() error {
+	var hdr struct {
+		Signature [4]byte
+		Size      uint32
+		Version   uint32
+		Items     uint32
+	}
+
+	entry, ok := o.table[sevMetadataGUID]
+
+	if !ok || len(entry) < 4 {
+		return errors.New("metadata not found")
+	}
+
+	off := int(binary.LittleEndian.Uint32(entry))
+	start := len(o.data) - off
+
+	if off > len(o.data) || start+16 > len(o.data) {
+		return errors.New("invalid metadata offset")
+	}
+
+	if _, err := binary.Decode(o.data[start:], binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+
+	if string(hdr.Signature[:]) != "ASEV" {
+		return errors.New("invalid metadata signature")
+	}
+
+	end := start + int(hdr.Size)
+
+	if end > len(o.data) || int(hdr.Size) < 16+int(hdr.Items)*12 {
+		return errors.New("invalid metadata size")
+	}
+
+	o.sections = make([]ovmfSection, hdr.Items)
+
+	_, err := binary.Decode(o.data[start+16:end], binary.LittleEndian, o.sections)
+
+	return err
+}
+
+// gpa returns the image guest physical address, as mapped below 4GB.
+func (o *ovmf) gpa() uint64 {
+	return fourGB - uint64(len(o.data))
+}
+
+// resetEIP returns the SEV-ES AP reset vector.
+func (o *ovmf) resetEIP() (eip uint32, err error) {
+	entry, ok := o.table[sevESResetBlockGUID]
+
+	if !ok || len(entry) < 4 {
+		return 0, errors.New("SEV-ES reset block not found")
+	}
+
+	return binary.LittleEndian.Uint32(entry), nil
+}
+
+// hashTableGPA returns the kernel hashes table guest physical address.
+func (o *ovmf) hashTableGPA() (gpa uint64, err error) {
+	entry, ok := o.table[sevHashTableGUID]
+
+	if !ok || len(entry) < 4 {
+		return 0, errors.New("SEV hash table not found")
+	}
+
+	return uint64(binary.LittleEndian.Uint32(entry)), nil
+}
@@ -0,0 +1,267 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package policy implements declarative appraisal policies for AMD SEV-SNP
+// attestation reports, expressed in JSON:
+//
+//	{
+//	  "measurements": ["81aee09d..."],
+//	  "host_data": ["00112233..."],
+//	  "minimum_tcb": {"bootloader": 10, "tee": 0, "snp": 27, "microcode": 27},
+//	  "minimum_guest_svn": 1,
+//	  "vmpl": 0,
+//	  "allow_debug": false,
+//	  "policy": {"require": ["SINGLE_SOCKET"], "forbid": ["MIGRATE_MA"]},
+//	  "platform_info": {"forbid": ["SMT_EN"]}
+//	}
+//
+// Measurement, key digest, TCB, VMPL and debug rules are enforced by
+// [attest.Verify], through the options set with [Policy.Apply], while
+// remaining rules are evaluated with [Policy.Evaluate] on the verified report.
+package policy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+)
+
+// Hex represents a hex encoded byte array.
+type Hex []byte
+
+// UnmarshalJSON decodes a hex encoded JSON string.
+func (h *Hex) UnmarshalJSON(data []byte) (err error) {
+	var s string
+
+	if err = json.Unmarshal(data, &s); err != nil {
+		return
+	}
+
+	*h, err = hex.DecodeString(s)
+
+	return
+}
+
+// MarshalJSON encodes the byte array as hex encoded JSON string.
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+// TCB represents per component minimum security patch levels.
+type TCB struct {
+	Bootloader uint8 `json:"bootloader"`
+	TEE        uint8 `json:"tee"`
+	SNP        uint8 `json:"snp"`
+	Microcode  uint8 `json:"microcode"`
+}
+
+// Flags represents required and forbidden bitmap flags, named as returned by
+// the attest package decoders (e.g. [attest.Policy.Flags]).
+type Flags struct {
+	Require []string `json:"require,omitempty"`
+	Forbid  []string `json:"forbid,omitempty"`
+}
+
+// Policy represents an attestation report appraisal policy, empty fields are
+// not checked.
+type Policy struct {
+	// Measurements is the list of allowed launch measurements.
+	Measurements []Hex `json:"measurements,omitempty"`
+	// HostData is the list of allowed HOST_DATA values.
+	HostData []Hex `json:"host_data,omitempty"`
+	// IDKeyDigests is the list of allowed ID key digests.
+	IDKeyDigests []Hex `json:"id_key_digests,omitempty"`
+	// AuthorKeyDigests is the list of allowed author key digests.
+	AuthorKeyDigests []Hex `json:"author_key_digests,omitempty"`
+
+	// MinimumTCB is the minimum for the current, reported and committed
+	// TCB versions.
+	MinimumTCB *TCB `json:"minimum_tcb,omitempty"`
+	// MinimumGuestSVN is the minimum guest security version number.
+	MinimumGuestSVN uint32 `json:"minimum_guest_svn,omitempty"`
+	// VMPL is the expected VM Privilege Level.
+	VMPL *int `json:"vmpl,omitempty"`
+
+	// AllowDebug permits reports of guests launched with the debug policy
+	// bit set, if also permitted by the verification options.
+	AllowDebug bool `json:"allow_debug,omitempty"`
+	// Policy is the required and forbidden guest policy bits.
+	Policy Flags `json:"policy"`
+	// PlatformInfo is the required and forbidden platform information
+	// bits.
+	PlatformInfo Flags `json:"platform_info"`
+}
+
+// Parse parses a JSON encoded policy, unknown fields are rejected.
+func Parse(buf []byte) (p *Policy, err error) {
+	p = &Policy{}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+
+	if err = dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("invalid policy, %v", err)
+	}
+
+	if err = validFlags(p.Policy, attest.PolicyFlags()); err != nil {
+		return nil, fmt.Errorf("invalid policy flags, %v", err)
+	}
+
+	if err = validFlags(p.PlatformInfo, attest.PlatformInfoFlags()); err != nil {
+		return nil, fmt.Errorf("invalid platform info flags, %v", err)
+	}
+
+	return
+}
+
+func validFlags(f Flags, names []string) error {
+	for _, flag := range slices.Concat(f.Require, f.Forbid) {
+		if !slices.Contains(names, flag) {
+			return fmt.Errorf("unknown flag %s", flag)
+		}
+	}
+
+	return nil
+}
+
+func allowed(list []Hex, val []byte) bool {
+	return slices.ContainsFunc(list, func(h Hex) bool {
+		return bytes.Equal(h, val)
+	})
+}
+
+func checkFlags(name string, f Flags, set []string) (errs []error) {
+	for _, flag := range f.Require {
+		if !slices.Contains(set, flag) {
+			errs = append(errs, fmt.Errorf("%s %s required", name, flag))
+		}
+	}
+
+	for _, flag := range f.Forbid {
+		if slices.Contains(set, flag) {
+			errs = append(errs, fmt.Errorf("%s %s forbidden", name, flag))
+		}
+	}
+
+	return
+}
+
+// intersect returns the values of the argument list allowed by the policy
+// one, the policy list replaces an empty (unchecked) one. An error is
+// returned if no value remains allowed.
+func intersect(name string, list [][]byte, policy []Hex) (l [][]byte, err error) {
+	if len(policy) == 0 {
+		return list, nil
+	}
+
+	if len(list) == 0 {
+		for _, h := range policy {
+			l = append(l, h)
+		}
+
+		return
+	}
+
+	for _, v := range list {
+		if allowed(policy, v) {
+			l = append(l, v)
+		}
+	}
+
+	if len(l) == 0 {
+		return nil, fmt.Errorf("no %s allowed by both options and policy", name)
+	}
+
+	return
+}
+
+// Apply restricts the argument verification options according to the policy
+// measurement, key digest, TCB, VMPL and debug rules.
+//
+// Policy rules can only tighten existing options, as policies might come from
+// less trusted storage than options (e.g. link-time key digests): allowed
+// values are intersected, minimum TCB components raised and debug permitted
+// only if already allowed. An error is returned if the policy conflicts with
+// the options, in which case they are left unchanged.
+func (p *Policy) Apply(opts *attest.Options) (err error) {
+	o := *opts
+
+	if o.Measurements, err = intersect("measurements", opts.Measurements, p.Measurements); err != nil {
+		return
+	}
+
+	if o.IDKeyDigests, err = intersect("ID key digests", opts.IDKeyDigests, p.IDKeyDigests); err != nil {
+		return
+	}
+
+	if o.AuthorKeyDigests, err = intersect("author key digests", opts.AuthorKeyDigests, p.AuthorKeyDigests); err != nil {
+		return
+	}
+
+	if p.MinimumTCB != nil {
+		o.MinimumTCB = kds.TCBParts{
+			BlSpl:    max(o.MinimumTCB.BlSpl, p.MinimumTCB.Bootloader),
+			TeeSpl:   max(o.MinimumTCB.TeeSpl, p.MinimumTCB.TEE),
+			SnpSpl:   max(o.MinimumTCB.SnpSpl, p.MinimumTCB.SNP),
+			UcodeSpl: max(o.MinimumTCB.UcodeSpl, p.MinimumTCB.Microcode),
+		}
+	}
+
+	if p.VMPL != nil {
+		if o.VMPL != nil && *o.VMPL != *p.VMPL {
+			return fmt.Errorf("policy VMPL %d conflicts with options VMPL %d", *p.VMPL, *o.VMPL)
+		}
+
+		vmpl := *p.VMPL
+		o.VMPL = &vmpl
+	}
+
+	o.AllowDebug = o.AllowDebug && p.AllowDebug
+
+	*opts = o
+
+	return
+}
+
+// Evaluate appraises the argument report against the policy rules which are
+// not covered by [Policy.Apply], the report must have been verified with the
+// options it restricted. All violations are returned.
+func (p *Policy) Evaluate(r *spb.Report) (err error) {
+	var errs []error
+
+	if r == nil {
+		return errors.New("invalid report")
+	}
+
+	if len(p.HostData) > 0 && !allowed(p.HostData, r.HostData) {
+		errs = append(errs, fmt.Errorf("host data not allowed (%x)", r.HostData))
+	}
+
+	if r.GuestSvn < p.MinimumGuestSVN {
+		errs = append(errs, fmt.Errorf("guest SVN below minimum (%d)", r.GuestSvn))
+	}
+
+	if policy, e := attest.DecodePolicy(r.Policy); e != nil {
+		errs = append(errs, fmt.Errorf("invalid policy, %v", e))
+	} else {
+		errs = append(errs, checkFlags("policy", p.Policy, policy.Flags())...)
+	}
+
+	if info, e := attest.DecodePlatformInfo(r.PlatformInfo); e != nil {
+		errs = append(errs, fmt.Errorf("invalid platform info, %v", e))
+	} else {
+		errs = append(errs, checkFlags("platform", p.PlatformInfo, info.Flags())...)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,273 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+)
+
+var (
+	measurement = bytes.Repeat([]byte{0x81}, 48)
+	hostData    = bytes.Repeat([]byte{0x42}, 32)
+	idKey       = bytes.Repeat([]byte{0x1d}, 48)
+	authorKey   = bytes.Repeat([]byte{0xa0}, 48)
+)
+
+// testReport returns a fixture report of a guest launched with the SMT and
+// SINGLE_SOCKET policy bits, on a platform with SMT enabled.
+func testReport() *spb.Report {
+	return &spb.Report{
+		Measurement:     measurement,
+		HostData:        hostData,
+		IdKeyDigest:     idKey,
+		AuthorKeyDigest: authorKey,
+		GuestSvn:        2,
+		// reserved bit 17, SMT, SINGLE_SOCKET
+		Policy: 1<<17 | 1<<16 | 1<<20,
+		// SMT_EN
+		PlatformInfo: 1 << 0,
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		json  string
+		valid bool
+	}{
+		{"empty", `{}`, true},
+		{"full", `{
+			"measurements": ["00ff"],
+			"host_data": ["01"],
+			"id_key_digests": ["02"],
+			"author_key_digests": ["03"],
+			"minimum_tcb": {"bootloader": 1, "tee": 2, "snp": 3, "microcode": 4},
+			"minimum_guest_svn": 1,
+			"vmpl": 0,
+			"allow_debug": true,
+			"policy": {"require": ["SINGLE_SOCKET"], "forbid": ["MIGRATE_MA"]},
+			"platform_info": {"forbid": ["SMT_EN"]}
+		}`, true},
+		{"unknown field", `{"measurement": ["00"]}`, false},
+		{"unknown nested field", `{"minimum_tcb": {"fmc": 1}}`, false},
+		{"invalid hex", `{"measurements": ["0g"]}`, false},
+		{"unknown policy flag", `{"policy": {"require": ["SMT_EN"]}}`, false},
+		{"unknown platform flag", `{"platform_info": {"forbid": ["DEBUG"]}}`, false},
+		{"bit flag", `{"policy": {"forbid": ["bit17"]}}`, false},
+		{"invalid JSON", `{`, false},
+	} {
+		p, err := Parse([]byte(tt.json))
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+
+		if tt.valid && p == nil {
+			t.Errorf("%s: missing policy", tt.name)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	other := bytes.Repeat([]byte{0xff}, 48)
+
+	for _, tt := range []struct {
+		name   string
+		policy *Policy
+		opts   attest.Options
+		want   attest.Options
+		valid  bool
+	}{
+		{
+			"empty",
+			&Policy{},
+			attest.Options{AllowDebug: true},
+			attest.Options{},
+			true,
+		},
+		{
+			"unchecked lists",
+			&Policy{
+				Measurements:     []Hex{measurement},
+				IDKeyDigests:     []Hex{idKey},
+				AuthorKeyDigests: []Hex{authorKey},
+			},
+			attest.Options{},
+			attest.Options{
+				Measurements:     [][]byte{measurement},
+				IDKeyDigests:     [][]byte{idKey},
+				AuthorKeyDigests: [][]byte{authorKey},
+			},
+			true,
+		},
+		{
+			"intersected lists",
+			&Policy{
+				IDKeyDigests: []Hex{idKey, other},
+			},
+			attest.Options{
+				IDKeyDigests: [][]byte{idKey},
+			},
+			attest.Options{
+				IDKeyDigests: [][]byte{idKey},
+			},
+			true,
+		},
+		{
+			"narrowed lists",
+			&Policy{
+				Measurements: []Hex{measurement},
+			},
+			attest.Options{
+				Measurements: [][]byte{other, measurement},
+			},
+			attest.Options{
+				Measurements: [][]byte{measurement},
+			},
+			true,
+		},
+		{
+			"widened key digest",
+			&Policy{
+				AuthorKeyDigests: []Hex{other},
+			},
+			attest.Options{
+				AuthorKeyDigests: [][]byte{authorKey},
+			},
+			attest.Options{},
+			false,
+		},
+		{
+			"raised TCB",
+			&Policy{
+				MinimumTCB: &TCB{Bootloader: 1, TEE: 0, SNP: 27, Microcode: 20},
+			},
+			attest.Options{
+				MinimumTCB: kds.TCBParts{BlSpl: 3, TeeSpl: 1, SnpSpl: 8, UcodeSpl: 20},
+			},
+			attest.Options{
+				MinimumTCB: kds.TCBParts{BlSpl: 3, TeeSpl: 1, SnpSpl: 27, UcodeSpl: 20},
+			},
+			true,
+		},
+		{
+			"VMPL",
+			&Policy{VMPL: intPtr(2)},
+			attest.Options{},
+			attest.Options{VMPL: intPtr(2)},
+			true,
+		},
+		{
+			"matching VMPL",
+			&Policy{VMPL: intPtr(0)},
+			attest.Options{VMPL: intPtr(0)},
+			attest.Options{VMPL: intPtr(0)},
+			true,
+		},
+		{
+			"conflicting VMPL",
+			&Policy{VMPL: intPtr(1)},
+			attest.Options{VMPL: intPtr(0)},
+			attest.Options{},
+			false,
+		},
+		{
+			"debug allowed",
+			&Policy{AllowDebug: true},
+			attest.Options{AllowDebug: true},
+			attest.Options{AllowDebug: true},
+			true,
+		},
+		{
+			"debug not widened",
+			&Policy{AllowDebug: true},
+			attest.Options{},
+			attest.Options{},
+			true,
+		},
+	} {
+		opts := tt.opts
+		err := tt.policy.Apply(&opts)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+
+		if !tt.valid {
+			// options are left unchanged on error
+			tt.want = tt.opts
+		}
+
+		if !equalOptions(&opts, &tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, opts, tt.want)
+		}
+	}
+}
+
+func equalOptions(a *attest.Options, b *attest.Options) bool {
+	equalLists := func(a [][]byte, b [][]byte) bool {
+		return slices.EqualFunc(a, b, bytes.Equal)
+	}
+
+	equalVMPL := (a.VMPL == nil) == (b.VMPL == nil) && (a.VMPL == nil || *a.VMPL == *b.VMPL)
+
+	return equalLists(a.Measurements, b.Measurements) &&
+		equalLists(a.IDKeyDigests, b.IDKeyDigests) &&
+		equalLists(a.AuthorKeyDigests, b.AuthorKeyDigests) &&
+		a.MinimumTCB == b.MinimumTCB &&
+		equalVMPL &&
+		a.AllowDebug == b.AllowDebug
+}
+
+func TestEvaluate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy *Policy
+		report func(r *spb.Report)
+		valid  bool
+	}{
+		{"empty", &Policy{}, nil, true},
+		{"host data", &Policy{HostData: []Hex{hostData}}, nil, true},
+		{"host data not allowed", &Policy{HostData: []Hex{measurement}}, nil, false},
+		{"guest SVN", &Policy{MinimumGuestSVN: 2}, nil, true},
+		{"guest SVN below minimum", &Policy{MinimumGuestSVN: 3}, nil, false},
+		{"required policy", &Policy{Policy: Flags{Require: []string{"SMT", "SINGLE_SOCKET"}}}, nil, true},
+		{"missing policy", &Policy{Policy: Flags{Require: []string{"MEM_AES_256_XTS"}}}, nil, false},
+		{"forbidden policy", &Policy{Policy: Flags{Forbid: []string{"SINGLE_SOCKET"}}}, nil, false},
+		{"not forbidden policy", &Policy{Policy: Flags{Forbid: []string{"DEBUG", "MIGRATE_MA"}}}, nil, true},
+		{"required platform", &Policy{PlatformInfo: Flags{Require: []string{"SMT_EN"}}}, nil, true},
+		{"forbidden platform", &Policy{PlatformInfo: Flags{Forbid: []string{"SMT_EN"}}}, nil, false},
+		{"invalid policy", &Policy{}, func(r *spb.Report) { r.Policy = 0 }, false},
+		{"invalid platform", &Policy{}, func(r *spb.Report) { r.PlatformInfo = 1 << 63 }, false},
+	} {
+		r := testReport()
+
+		if tt.report != nil {
+			tt.report(r)
+		}
+
+		if err := tt.policy.Evaluate(r); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+
+	if err := (&Policy{}).Evaluate(nil); err == nil {
+		t.Error("expected error on missing report")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHReportExtension is the OpenSSH certificate extension name carrying the
+// raw attestation report of the certified host key.
+const SSHReportExtension = "sev-snp-report@tamago-sev-example"
+
+// SSHReportData returns the attestation report REPORT_DATA value which binds
+// a report to an SSH host key, computed as the SHA-512 hash of the key in SSH
+// wire format.
+func SSHReportData(key ssh.PublicKey) []byte {
+	sum := sha512.Sum512(key.Marshal())
+	return sum[:]
+}
+
+// VerifySSHHostKey verifies an SSH host key presented by the unikernel, the
+// key must be a self-signed OpenSSH host certificate carrying an attestation
+// report (see [SSHReportExtension]) bound to the certified key.
+func VerifySSHHostKey(key ssh.PublicKey, opts *Options) (res *Result, err error) {
+	cert, ok := key.(*ssh.Certificate)
+
+	if !ok {
+		return nil, errors.New("host key is not a certificate")
+	}
+
+	if cert.CertType != ssh.HostCert {
+		return nil, errors.New("certificate is not a host certificate")
+	}
+
+	if !bytes.Equal(cert.SignatureKey.Marshal(), cert.Key.Marshal()) {
+		return nil, errors.New("certificate is not self-signed")
+	}
+
+	checker := &ssh.CertChecker{}
+
+	if err = checker.CheckCert("", cert); err != nil {
+		return nil, fmt.Errorf("invalid certificate, %v", err)
+	}
+
+	raw, ok := cert.Extensions[SSHReportExtension]
+
+	if !ok {
+		return nil, errors.New("missing attestation report")
+	}
+
+	return Verify([]byte(raw), SSHReportData(cert.Key), opts)
+}
+
+// HostKeyCallback returns an [ssh.HostKeyCallback] which accepts only host
+// keys successfully verified with [VerifySSHHostKey]. When the pin argument is
+// not nil the certified host key must also match it.
+//
+// The client [ssh.ClientConfig] must allow host certificate algorithms (e.g.
+// [ssh.CertAlgoECDSA256v01]), which is the case with default settings.
+func HostKeyCallback(pin ssh.PublicKey, opts *Options) ssh.HostKeyCallback {
+	return func(_ string, _ net.Addr, key ssh.PublicKey) (err error) {
+		if _, err = VerifySSHHostKey(key, opts); err != nil {
+			return
+		}
+
+		if pin == nil {
+			return
+		}
+
+		cert := key.(*ssh.Certificate)
+
+		if !bytes.Equal(cert.Key.Marshal(), pin.Marshal()) {
+			return errors.New("host key mismatch")
+		}
+
+		return
+	}
+}
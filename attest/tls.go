@@ -0,0 +1,93 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package attest
+
+import (
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// ReportExtensionOID is the X.509 certificate extension identifier carrying
+// the raw attestation report, encoded as an ASN.1 OCTET STRING, of the
+// certified public key.
+//
+// The identifier is allocated under IANA Private Enterprise Number 32473,
+// which RFC 5612 reserves for documentation and examples, production
+// deployments should override it with an identifier under their own arc.
+var ReportExtensionOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1, 1}
+
+// TLSReportData returns the attestation report REPORT_DATA value which binds
+// a report to a TLS certificate, computed as the SHA-512 hash of its DER
+// encoded SubjectPublicKeyInfo.
+func TLSReportData(spki []byte) []byte {
+	sum := sha512.Sum512(spki)
+	return sum[:]
+}
+
+// VerifyCertificate verifies an X.509 certificate presented by the unikernel,
+// the certificate must be self-signed and carry an attestation report (see
+// [ReportExtensionOID]) bound to its public key.
+//
+// Certificate validity times are not checked as the unikernel clock is not
+// trusted.
+func VerifyCertificate(cert *x509.Certificate, opts *Options) (res *Result, err error) {
+	var raw []byte
+
+	if err = cert.CheckSignatureFrom(cert); err != nil {
+		return nil, fmt.Errorf("certificate is not self-signed, %v", err)
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(ReportExtensionOID) {
+			continue
+		}
+
+		if _, err = asn1.Unmarshal(ext.Value, &raw); err != nil {
+			return nil, fmt.Errorf("could not parse report extension, %v", err)
+		}
+
+		return Verify(raw, TLSReportData(cert.RawSubjectPublicKeyInfo), opts)
+	}
+
+	return nil, errors.New("missing attestation report")
+}
+
+// VerifyPeerCertificate returns a [tls.Config.VerifyPeerCertificate] function
+// which accepts only peer certificates successfully verified with
+// [VerifyCertificate].
+func VerifyPeerCertificate(opts *Options) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) (err error) {
+		if len(rawCerts) == 0 {
+			return errors.New("missing peer certificate")
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+
+		if err != nil {
+			return fmt.Errorf("could not parse peer certificate, %v", err)
+		}
+
+		_, err = VerifyCertificate(cert, opts)
+
+		return
+	}
+}
+
+// TLSConfig returns a client TLS configuration which authenticates the
+// unikernel through [VerifyPeerCertificate] in place of standard chain
+// verification.
+func TLSConfig(opts *Options) *tls.Config {
+	return &tls.Config{
+		// the self-signed certificate is verified by the attestation
+		// report
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: VerifyPeerCertificate(opts),
+	}
+}
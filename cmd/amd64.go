@@ -17,25 +17,27 @@ import (
 
 	"github.com/usbarmory/go-boot/shell"
 	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
 )
 
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "info",
 		Help: "device information",
 		Fn:   infoCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "cpuid",
-		Args:    2,
-		Pattern: regexp.MustCompile(`^cpuid\s+([[:xdigit:]]+) ([[:xdigit:]]+)$`),
-		Syntax:  "<leaf> <subleaf>",
+		Args:    3,
+		Pattern: regexp.MustCompile(`^cpuid(?:\s+(trusted|diff))?(?:\s+([[:xdigit:]]+) ([[:xdigit:]]+))?$`),
+		Syntax:  "(trusted|diff)? <leaf> <subleaf>",
 		Help:    "show CPU capabilities",
 		Fn:      cpuidCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "msr",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^msr\s+([[:xdigit:]]+)$`),
@@ -44,7 +46,7 @@ func init() {
 		Fn:      msrCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "lspci",
 		Help: "list PCI devices",
 		Fn:   lspciCmd,
@@ -82,20 +84,48 @@ func infoCmd(_ *shell.Interface, _ []string) (string, error) {
 
 func cpuidCmd(_ *shell.Interface, arg []string) (string, error) {
 	var res bytes.Buffer
+	var eax, ebx, ecx, edx uint32
+
+	if arg[0] == "diff" && len(arg[1]) == 0 {
+		return cpuidDiff()
+	}
+
+	if len(arg[1]) == 0 {
+		return "", fmt.Errorf("missing leaf and subleaf")
+	}
 
-	leaf, err := strconv.ParseUint(arg[0], 16, 32)
+	leaf, err := strconv.ParseUint(arg[1], 16, 32)
 
 	if err != nil {
 		return "", fmt.Errorf("invalid leaf, %v", err)
 	}
 
-	subleaf, err := strconv.ParseUint(arg[1], 10, 32)
+	subleaf, err := strconv.ParseUint(arg[2], 10, 32)
 
 	if err != nil {
 		return "", fmt.Errorf("invalid subleaf, %v", err)
 	}
 
-	eax, ebx, ecx, edx := x64.AMD64.CPUID(uint32(leaf), uint32(subleaf))
+	switch arg[0] {
+	case "trusted":
+		if eax, ebx, ecx, edx, err = kvm.TrustedCPUID(uint32(leaf), uint32(subleaf)); err != nil {
+			return "", err
+		}
+	case "diff":
+		e := &kvm.CPUIDEntry{Leaf: uint32(leaf), Subleaf: uint32(subleaf)}
+
+		if e.EAX, e.EBX, e.ECX, e.EDX, err = kvm.TrustedCPUID(e.Leaf, e.Subleaf); err != nil {
+			return "", err
+		}
+
+		if eax, ebx, ecx, edx, err = kvm.HypervisorCPUID(e.Leaf, e.Subleaf); err != nil {
+			return "", fmt.Errorf("could not get hypervisor CPUID, %v", err)
+		}
+
+		return cpuidDiffHeader + cpuidDiffEntry(e, eax, ebx, ecx, edx), nil
+	default:
+		eax, ebx, ecx, edx = x64.AMD64.CPUID(uint32(leaf), uint32(subleaf))
+	}
 
 	fmt.Fprintf(&res, "EAX      EBX      ECX      EDX\n")
 	fmt.Fprintf(&res, "%08x %08x %08x %08x\n", eax, ebx, ecx, edx)
@@ -103,6 +133,60 @@ func cpuidCmd(_ *shell.Interface, arg []string) (string, error) {
 	return res.String(), nil
 }
 
+const cpuidDiffHeader = "Leaf     Subleaf       EAX      EBX      ECX      EDX\n"
+
+// cpuidDiffEntry formats a CPUID page entry along with the argument values
+// reported by the hypervisor, marking disagreeing registers.
+func cpuidDiffEntry(e *kvm.CPUIDEntry, eax, ebx, ecx, edx uint32) string {
+	var res bytes.Buffer
+
+	mark := func(page, live uint32) string {
+		if page != live {
+			return "*"
+		}
+
+		return " "
+	}
+
+	fmt.Fprintf(&res, "%08x %08x page %08x %08x %08x %08x\n", e.Leaf, e.Subleaf, e.EAX, e.EBX, e.ECX, e.EDX)
+	fmt.Fprintf(&res, "%17s live %08x%s%08x%s%08x%s%08x%s\n", "",
+		eax, mark(e.EAX, eax), ebx, mark(e.EBX, ebx), ecx, mark(e.ECX, ecx), edx, mark(e.EDX, edx))
+
+	return res.String()
+}
+
+// cpuidDiff compares all CPUID page entries against the values reported by
+// the hypervisor, only disagreeing functions are shown.
+func cpuidDiff() (string, error) {
+	var res bytes.Buffer
+
+	entries, err := kvm.CPUIDPage()
+
+	if err != nil {
+		return "", err
+	}
+
+	res.WriteString(cpuidDiffHeader)
+
+	for _, e := range entries {
+		eax, ebx, ecx, edx, err := kvm.HypervisorCPUID(e.Leaf, e.Subleaf)
+
+		if err != nil {
+			return "", fmt.Errorf("could not get hypervisor CPUID, %v", err)
+		}
+
+		if eax == e.EAX && ebx == e.EBX && ecx == e.ECX && edx == e.EDX {
+			continue
+		}
+
+		res.WriteString(cpuidDiffEntry(&e, eax, ebx, ecx, edx))
+	}
+
+	fmt.Fprintf(&res, "\n%d entries in CPUID page\n", len(entries))
+
+	return res.String(), nil
+}
+
 func msrCmd(_ *shell.Interface, arg []string) (string, error) {
 	var res bytes.Buffer
 
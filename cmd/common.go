@@ -26,17 +26,23 @@ var (
 	Resolver = "8.8.8.8:53"
 )
 
+// add registers a shell command, its invocations are measured (see
+// measureCommand).
+func add(c shell.Cmd) {
+	shell.Add(measureCommand(c))
+}
+
 func init() {
 	Banner = fmt.Sprintf("go-boot • %s/%s (%s) • UEFI x64",
 		runtime.GOOS, runtime.GOARCH, runtime.Version())
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "build",
 		Help: "build information",
 		Fn:   buildInfoCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "exit,quit",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^(exit|quit)$`),
@@ -44,19 +50,19 @@ func init() {
 		Fn:      exitCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "stack",
 		Help: "goroutine stack trace (current)",
 		Fn:   stackCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "stackall",
 		Help: "goroutine stack trace (all)",
 		Fn:   stackallCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "date",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^date(.*)`),
@@ -65,13 +71,13 @@ func init() {
 		Fn:      dateCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "uptime",
 		Help: "show system running time",
 		Fn:   uptimeCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "dns",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^dns (.*)`),
@@ -20,7 +20,7 @@ import (
 const maxBufferSize = 102400
 
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "peek",
 		Args:    2,
 		Pattern: regexp.MustCompile(`^peek ([[:xdigit:]]+) (\d+)$`),
@@ -29,7 +29,7 @@ func init() {
 		Fn:      memReadCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "poke",
 		Args:    2,
 		Pattern: regexp.MustCompile(`^poke ([[:xdigit:]]+) ([[:xdigit:]]+)$`),
@@ -22,6 +22,7 @@ import (
 
 	"github.com/usbarmory/go-net"
 
+	"github.com/usbarmory/tamago-sev-example/internal/https"
 	"github.com/usbarmory/tamago-sev-example/internal/ssh"
 )
 
@@ -34,7 +35,7 @@ const (
 )
 
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "net-gve",
 		Args:    3,
 		Pattern: regexp.MustCompile(`^net-gve (\S+) (\S+)( debug)?$`),
@@ -45,6 +46,9 @@ func init() {
 }
 
 func gvnicCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	// Queues and packet buffers are reserved by the driver from the
+	// default shared region, as it has no hook to map them through a
+	// bounce pool client.
 	gve := &gvnic.GVE{
 		Device: pci.Probe(
 			0,
@@ -80,15 +84,24 @@ func gvnicCmd(_ *shell.Interface, arg []string) (res string, err error) {
 		log.Printf("network initialized (%s %s)\n", arg[0], gve.MAC())
 		log.Printf("starting debug servers:\n")
 		log.Printf("\thttp://%s:80/debug/pprof\n", ip)
+		log.Printf("\thttps://%s:443/debug/pprof\n", ip)
 		log.Printf("\tssh://%s:22\n", ip)
 
 		go ssh.Start(Banner)
 		go http.ListenAndServe(":80", nil)
+		go https.Start()
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopNetwork("net-gve", func() error {
+		cancel()
+		return disableBusMaster(gve.Device)
+	})
+
 	// The gVNIC driver does not yet use interrupts, for now we block here
 	log.Printf("stopping serial console\n")
-	iface.Start(context.Background())
+	iface.Start(ctx)
 
 	return "", nil
 }
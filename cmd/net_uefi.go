@@ -24,6 +24,7 @@ import (
 	// maintained set of TLS roots for any potential TLS client requests
 	_ "golang.org/x/crypto/x509roots/fallback"
 
+	"github.com/usbarmory/tamago-sev-example/internal/https"
 	"github.com/usbarmory/tamago-sev-example/internal/ssh"
 )
 
@@ -32,7 +33,7 @@ const receiveMask = uefi.EFI_SIMPLE_NETWORK_RECEIVE_UNICAST |
 	uefi.EFI_SIMPLE_NETWORK_RECEIVE_PROMISCUOUS
 
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "net-uefi",
 		Args:    4,
 		Pattern: regexp.MustCompile(`^net-uefi (\S+) (\S+) (\S+)( debug)?$`),
@@ -89,7 +90,15 @@ func netCmd(_ *shell.Interface, arg []string) (res string, err error) {
 	}
 
 	iface.Stack.EnableICMP()
-	go iface.Start(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go iface.Start(ctx)
+
+	stopNetwork("net-uefi", func() error {
+		cancel()
+		nic.Shutdown()
+		return nic.Stop()
+	})
 
 	// hook interface into Go runtime
 	net.SocketFunc = iface.Stack.Socket
@@ -99,10 +108,12 @@ func netCmd(_ *shell.Interface, arg []string) (res string, err error) {
 
 		log.Printf("starting debug servers:\n")
 		log.Printf("\thttp://%s:80/debug/pprof\n", ip)
+		log.Printf("\thttps://%s:443/debug/pprof\n", ip)
 		log.Printf("\tssh://%s:22\n", ip)
 
 		go ssh.Start(Banner)
 		go http.ListenAndServe(":80", nil)
+		go https.Start()
 	}
 
 	return fmt.Sprintf("network initialized (%s %s)\n", arg[0], mac), nil
@@ -27,6 +27,7 @@ import (
 	"github.com/usbarmory/go-net"
 	"github.com/usbarmory/go-net/virtio"
 
+	"github.com/usbarmory/tamago-sev-example/internal/https"
 	"github.com/usbarmory/tamago-sev-example/internal/ssh"
 )
 
@@ -45,7 +46,7 @@ const (
 )
 
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "net-virtio",
 		Args:    4,
 		Pattern: regexp.MustCompile(`^net-virtio (\S+) (\S+) (\S+)( debug)?$`),
@@ -56,6 +57,9 @@ func init() {
 }
 
 func probeNIC() (nic *vnet.Net) {
+	// Rings and packet buffers are reserved by the driver from the
+	// default shared region, as it has no hook to map them through a
+	// bounce pool client.
 	nic = &vnet.Net{
 		IRQ:          VIRTIO_NET_IRQ,
 		MTU:          gnet.MTU,
@@ -121,6 +125,17 @@ func virtioNetCmd(_ *shell.Interface, arg []string) (res string, err error) {
 
 	go nic.Start()
 
+	stopNetwork("net-virtio", func() error {
+		switch t := nic.Transport.(type) {
+		case *virtio.PCI:
+			return disableBusMaster(t.Device)
+		case *virtio.LegacyPCI:
+			return disableBusMaster(t.Device)
+		}
+
+		return nil
+	})
+
 	mac, _ := iface.Stack.HardwareAddress()
 
 	if len(arg[3]) > 0 {
@@ -129,10 +144,12 @@ func virtioNetCmd(_ *shell.Interface, arg []string) (res string, err error) {
 		log.Printf("network initialized (%s %s)\n", arg[0], mac)
 		log.Printf("starting debug servers:\n")
 		log.Printf("\thttp://%s:80/debug/pprof\n", ip)
+		log.Printf("\thttps://%s:443/debug/pprof\n", ip)
 		log.Printf("\tssh://%s:22\n", ip)
 
 		go ssh.Start(Banner)
 		go http.ListenAndServe(":80", nil)
+		go https.Start()
 	}
 
 	return fmt.Sprintf("network initialized (%s %s)\n", arg[0], mac), nil
@@ -0,0 +1,73 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/go-boot/shell"
+	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/efifs"
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+)
+
+func init() {
+	if !sev.Features(x64.AMD64).SEV.SNP {
+		return
+	}
+
+	add(shell.Cmd{
+		Name:    "seal",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^seal (\S+) (.*)`),
+		Syntax:  "<path> <data>",
+		Help:    "seal data to UEFI volume",
+		Fn:      sealCmd,
+	})
+
+	add(shell.Cmd{
+		Name:    "unseal",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^unseal (\S+)$`),
+		Syntax:  "<path>",
+		Help:    "unseal data from UEFI volume",
+		Fn:      unsealCmd,
+	})
+}
+
+func sealCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	blob, err := kvm.Seal(nil, []byte(arg[1]))
+
+	if err != nil {
+		return "", fmt.Errorf("could not seal, %v", err)
+	}
+
+	if err = efifs.WriteFile(arg[0], blob); err != nil {
+		return
+	}
+
+	return fmt.Sprintf("sealed %d bytes to %s\n", len(arg[1]), arg[0]), nil
+}
+
+func unsealCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	blob, err := readFile(arg[0])
+
+	if err != nil {
+		return
+	}
+
+	data, err := kvm.Unseal(nil, blob)
+
+	if err != nil {
+		return
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/go-boot/shell"
+	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/secrets"
+)
+
+func init() {
+	if !sev.Features(x64.AMD64).SEV.SNP {
+		return
+	}
+
+	add(shell.Cmd{
+		Name:    "secrets",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^secrets(?: (fetch|get|clear)(?: (\S+))?)?$`),
+		Syntax:  "(fetch <url>|get <name>|clear)?",
+		Help:    "key broker secrets",
+		Fn:      secretsCmd,
+	})
+}
+
+func secretsCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	switch arg[0] {
+	case "fetch":
+		if net.SocketFunc == nil {
+			return "", fmt.Errorf("network unavailable")
+		}
+
+		names, err := secrets.Provision(nil, arg[1])
+
+		if err != nil {
+			return "", fmt.Errorf("could not provision secrets, %v", err)
+		}
+
+		return fmt.Sprintf("provisioned %d secrets %v\n", len(names), names), nil
+	case "get":
+		val, ok := secrets.Get(arg[1])
+
+		if !ok {
+			return "", fmt.Errorf("secret not found")
+		}
+
+		return fmt.Sprintf("%x\n", val), nil
+	case "clear":
+		secrets.Clear()
+		return
+	}
+
+	for _, name := range secrets.Names() {
+		val, _ := secrets.Get(name)
+		fmt.Fprintf(&buf, "%-20s (%d bytes)\n", name, len(val))
+	}
+
+	return buf.String(), nil
+}
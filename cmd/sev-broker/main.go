@@ -0,0 +1,151 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The sev-broker command implements a minimal key broker, for development and
+// testing, which releases secrets to tamago-sev-example guests presenting a
+// valid attestation report.
+//
+// Secrets are read from a JSON file mapping names to string values:
+//
+//	{"disk-key": "...", "api-token": "..."}
+//
+// Responses are signed with the broker identity key, an Ed25519 seed (hex)
+// read from the -key file or generated if missing, whose public key must be
+// set at guest link time (BROKER_KEY).
+//
+// Guests request them with the `secrets fetch http://<addr>/secrets` command.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+)
+
+// identity returns the broker identity key from the argument path, a new one
+// is generated if the file does not exist.
+func identity(path string) (key ed25519.PrivateKey, err error) {
+	buf, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		rand.Read(seed)
+
+		if err = os.WriteFile(path, []byte(hex.EncodeToString(seed)), 0600); err != nil {
+			return
+		}
+
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(buf)))
+
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid key file")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listening address")
+	keyPath := flag.String("key", "broker.key", "identity key file (hex Ed25519 seed), generated if missing")
+	path := flag.String("secrets", "secrets.json", "secrets file (JSON name to value map)")
+	chain := flag.String("chain", "", "endorsement key certificate chain (PEM) for offline verification")
+	measurements := flag.String("measurements", "", "comma separated list of allowed launch measurements (hex)")
+	debug := flag.Bool("debug", false, "allow guests launched with the debug policy bit set")
+	anyMeasurement := flag.Bool("insecure-any-measurement", false, "release secrets to guests with any launch measurement")
+
+	flag.Parse()
+
+	opts := &attest.Options{
+		AllowDebug: *debug,
+	}
+
+	if len(*chain) > 0 {
+		buf, err := os.ReadFile(*chain)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if opts.Chain, err = attest.ParseChain(buf); err != nil {
+			log.Fatalf("could not parse chain, %v", err)
+		}
+	}
+
+	for _, m := range strings.Split(*measurements, ",") {
+		if len(m) == 0 {
+			continue
+		}
+
+		buf, err := hex.DecodeString(m)
+
+		if err != nil {
+			log.Fatalf("invalid measurement, %v", err)
+		}
+
+		opts.Measurements = append(opts.Measurements, buf)
+	}
+
+	if len(opts.Measurements) == 0 && !*anyMeasurement {
+		log.Fatal("no allowed measurements, see -measurements or -insecure-any-measurement")
+	}
+
+	key, err := identity(*keyPath)
+
+	if err != nil {
+		log.Fatalf("could not load identity key, %v", err)
+	}
+
+	log.Printf("broker key %x", key.Public())
+
+	broker := &attest.Broker{
+		Options: opts,
+		Key:     key,
+		Secrets: func(res *attest.Result) (secrets map[string][]byte, err error) {
+			var values map[string]string
+
+			buf, err := os.ReadFile(*path)
+
+			if err != nil {
+				return
+			}
+
+			if err = json.Unmarshal(buf, &values); err != nil {
+				return
+			}
+
+			secrets = make(map[string][]byte)
+
+			for name, val := range values {
+				secrets[name] = []byte(val)
+			}
+
+			log.Printf("releasing %d secrets to measurement %x", len(secrets), res.Report.Measurement)
+
+			return
+		},
+	}
+
+	http.Handle("/secrets", broker)
+
+	log.Printf("key broker listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,178 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The sev-idblock command produces AMD SEV-SNP ID blocks and ID authentication
+// information, for QEMU `sev-snp-guest` objects, which restrict guest launches
+// to the expected measurement and policy.
+//
+// The ID block is signed with an ECDSA P-384 ID key, which is optionally
+// signed by an ECDSA P-384 author key. Keys can be generated with:
+//
+//	sev-idblock -genkey id.pem
+//	sev-idblock -genkey author.pem
+//
+// The measurement is typically computed with `sev-measure`:
+//
+//	sev-idblock -measurement <hex> -id-key id.pem -author-key author.pem
+//
+// The resulting QEMU parameters can be passed to the Makefile `SNP_ID`
+// variable, while the key digests can be passed to `ID_KEY_DIGEST` and
+// `AUTHOR_KEY_DIGEST` for enforcement by `sev-report verify`.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+)
+
+func genKey(path string) (err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	if err != nil {
+		return
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func loadKey(path string) (key *ecdsa.PrivateKey, err error) {
+	buf, err := os.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	block, _ := pem.Decode(buf)
+
+	if block == nil {
+		return nil, errors.New("invalid PEM file")
+	}
+
+	if key, err = x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return
+	}
+
+	if key, ok := k.(*ecdsa.PrivateKey); ok {
+		return key, nil
+	}
+
+	return nil, errors.New("invalid key type, ECDSA required")
+}
+
+func decodeHex(name string, val string, size int) []byte {
+	buf, err := hex.DecodeString(val)
+
+	if err != nil || len(buf) > size {
+		log.Fatalf("invalid %s", name)
+	}
+
+	return buf
+}
+
+func main() {
+	keyPath := flag.String("genkey", "", "generate an ECDSA P-384 key (PEM) and exit")
+	measurement := flag.String("measurement", "", "expected launch measurement (hex)")
+	familyID := flag.String("family-id", "", "family ID (hex, 16 bytes)")
+	imageID := flag.String("image-id", "", "image ID (hex, 16 bytes)")
+	guestSVN := flag.Uint("guest-svn", 0, "guest security version number")
+	policy := flag.String("policy", "0x30000", "guest policy")
+	idKeyPath := flag.String("id-key", "id.pem", "ID key (PEM)")
+	authorKeyPath := flag.String("author-key", "", "author key (PEM)")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	if len(*keyPath) > 0 {
+		if err := genKey(*keyPath); err != nil {
+			log.Fatalf("could not generate key, %v", err)
+		}
+
+		return
+	}
+
+	ld := decodeHex("measurement", *measurement, 48)
+
+	if len(ld) != 48 {
+		log.Fatal("missing or invalid -measurement")
+	}
+
+	p, err := strconv.ParseUint(*policy, 0, 64)
+
+	if err != nil {
+		log.Fatalf("invalid policy, %v", err)
+	}
+
+	b := &attest.IDBlock{
+		Version:  attest.IDBlockVersion,
+		GuestSVN: uint32(*guestSVN),
+		Policy:   p,
+	}
+
+	copy(b.LD[:], ld)
+	copy(b.FamilyID[:], decodeHex("family ID", *familyID, 16))
+	copy(b.ImageID[:], decodeHex("image ID", *imageID, 16))
+
+	idKey, err := loadKey(*idKeyPath)
+
+	if err != nil {
+		log.Fatalf("could not load ID key, %v", err)
+	}
+
+	var authorKey *ecdsa.PrivateKey
+
+	if len(*authorKeyPath) > 0 {
+		if authorKey, err = loadKey(*authorKeyPath); err != nil {
+			log.Fatalf("could not load author key, %v", err)
+		}
+	}
+
+	auth, err := b.Sign(idKey, authorKey)
+
+	if err != nil {
+		log.Fatalf("could not sign ID block, %v", err)
+	}
+
+	idKeyDigest, _ := attest.KeyDigest(&idKey.PublicKey)
+
+	params := fmt.Sprintf(",id-block=%s,id-auth=%s",
+		base64.StdEncoding.EncodeToString(b.Bytes()),
+		base64.StdEncoding.EncodeToString(auth))
+
+	fmt.Printf("ID_KEY_DIGEST=%x\n", idKeyDigest)
+
+	if authorKey != nil {
+		authorKeyDigest, _ := attest.KeyDigest(&authorKey.PublicKey)
+		fmt.Printf("AUTHOR_KEY_DIGEST=%x\n", authorKeyDigest)
+		params += ",author-key-enabled=on"
+	}
+
+	fmt.Printf("SNP_POLICY=%#x\n", p)
+	fmt.Printf("SNP_ID=%s\n", params)
+}
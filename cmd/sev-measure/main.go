@@ -0,0 +1,140 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// The sev-measure command computes the expected AMD SEV-SNP launch
+// measurement of tamago-sev-example guests launched under QEMU/KVM, as shown
+// by the `sev-report` command MEASUREMENT field.
+//
+// The measurement covers the OVMF image, the kernel hashes table (QEMU
+// `kernel-hashes=on`) for the EFI binary passed with `-kernel`, the vCPU count
+// and signature and the VMSA SEV_FEATURES:
+//
+//	sev-measure -ovmf OVMF.amdsev.fd -kernel tamago-sev-example.efi -vcpus 4 -vcpu-type EPYC-Milan
+//
+// The guest policy is not covered by the launch digest, it is reported next
+// to the measurement for verification (see `sev-broker -measurements`).
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+)
+
+// vCPU models family, model and stepping (QEMU target/i386/cpu.c)
+var vcpuTypes = map[string][3]uint32{
+	"EPYC":       {23, 1, 2},
+	"EPYC-v1":    {23, 1, 2},
+	"EPYC-v2":    {23, 1, 2},
+	"EPYC-v3":    {23, 1, 2},
+	"EPYC-v4":    {23, 1, 2},
+	"EPYC-Rome":  {23, 49, 0},
+	"EPYC-Milan": {25, 1, 1},
+	"EPYC-Genoa": {25, 17, 0},
+}
+
+// Measurement represents the sev-measure JSON output.
+type Measurement struct {
+	Measurement string `json:"measurement"`
+	Policy      string `json:"policy"`
+	VCPUs       int    `json:"vcpus"`
+	VCPUSig     string `json:"vcpu_sig"`
+	Features    string `json:"sev_features"`
+}
+
+func readFile(path string) []byte {
+	if len(path) == 0 {
+		return nil
+	}
+
+	buf, err := os.ReadFile(path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buf
+}
+
+func parseHex(name string, val string, bitSize int) uint64 {
+	n, err := strconv.ParseUint(val, 0, bitSize)
+
+	if err != nil {
+		log.Fatalf("invalid %s, %v", name, err)
+	}
+
+	return n
+}
+
+func main() {
+	ovmf := flag.String("ovmf", "OVMF.amdsev.fd", "OVMF firmware image")
+	kernel := flag.String("kernel", "", "EFI binary passed with -kernel (kernel-hashes=on)")
+	initrd := flag.String("initrd", "", "image passed with -initrd")
+	appendCmdline := flag.String("append", "", "command line passed with -append")
+	vcpus := flag.Int("vcpus", 1, "number of vCPUs")
+	vcpuType := flag.String("vcpu-type", "", "vCPU model (e.g. EPYC-v4, EPYC-Milan, EPYC-Genoa)")
+	vcpuSig := flag.String("vcpu-sig", "", "vCPU CPUID(1).EAX signature (alternative to -vcpu-type)")
+	features := flag.String("features", "0x1", "VMSA SEV_FEATURES")
+	policy := flag.String("policy", "0x30000", "guest policy")
+	output := flag.Bool("json", false, "JSON output")
+
+	flag.Parse()
+	log.SetFlags(0)
+
+	opts := &attest.LaunchOptions{
+		OVMF:     readFile(*ovmf),
+		Kernel:   readFile(*kernel),
+		Initrd:   readFile(*initrd),
+		Append:   *appendCmdline,
+		VCPUs:    *vcpus,
+		Features: parseHex("features", *features, 64),
+	}
+
+	switch {
+	case len(*vcpuSig) > 0:
+		opts.VCPUSignature = uint32(parseHex("vCPU signature", *vcpuSig, 32))
+	case len(*vcpuType) > 0:
+		t, ok := vcpuTypes[*vcpuType]
+
+		if !ok {
+			log.Fatalf("unsupported vCPU type %s", *vcpuType)
+		}
+
+		opts.VCPUSignature = attest.CPUSignature(t[0], t[1], t[2])
+	default:
+		log.Fatal("missing -vcpu-type or -vcpu-sig")
+	}
+
+	digest, err := attest.LaunchDigest(opts)
+
+	if err != nil {
+		log.Fatalf("could not compute launch digest, %v", err)
+	}
+
+	if !*output {
+		fmt.Println(hex.EncodeToString(digest))
+		return
+	}
+
+	buf, err := json.MarshalIndent(&Measurement{
+		Measurement: hex.EncodeToString(digest),
+		Policy:      fmt.Sprintf("%#x", parseHex("policy", *policy, 64)),
+		VCPUs:       opts.VCPUs,
+		VCPUSig:     fmt.Sprintf("%#x", opts.VCPUSignature),
+		Features:    fmt.Sprintf("%#x", opts.Features),
+	}, "", "\t")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(buf))
+}
@@ -8,70 +8,326 @@ package cmd
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"log"
 	"net"
 	"regexp"
 	"runtime/goos"
-
-	"github.com/google/go-sev-guest/verify"
+	"strconv"
+	"strings"
 
 	"github.com/usbarmory/tamago/kvm/sev"
 
 	"github.com/usbarmory/go-boot/shell"
 	"github.com/usbarmory/go-boot/uefi/x64"
 
+	"github.com/usbarmory/tamago-sev-example/attest"
+	"github.com/usbarmory/tamago-sev-example/attest/policy"
 	"github.com/usbarmory/tamago-sev-example/internal/kvm"
 )
 
+// Expected ID and author key digests (hex), enforced by `sev-report verify`
+// when set (see `sev-idblock`).
+var (
+	IDKeyDigest     string
+	AuthorKeyDigest string
+)
+
 func init() {
 	if !sev.Features(x64.AMD64).SEV.SEV {
 		return
 	}
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "sev",
 		Help: "AMD SEV-SNP information",
 		Fn:   sevCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "sev-report",
-		Args:    1,
-		Pattern: regexp.MustCompile(`^sev-report(?: (raw|verify))?$`),
-		Syntax:  "(raw|verify)?",
+		Args:    5,
+		Pattern: regexp.MustCompile(`^sev-report(?: (raw|json|proto|certs|verify)(?: (\S+))?(?: policy (\S+))?)?(?: (nonce|file) (\S+))?$`),
+		Syntax:  "(raw|json|proto|certs|verify (<chain>)? (policy <path>)?)? (nonce <hex>|file <path>)?",
 		Help:    "AMD SEV-SNP attestation report",
 		Fn:      attestationCmd,
 	})
 
-	shell.Add(shell.Cmd{
-		Name: "sev-kdf",
-		Help: "AMD SEV-SNP key derivation",
-		Fn:   kdfCmd,
+	add(shell.Cmd{
+		Name:    "sev-kdf",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^sev-kdf((?: \S+=\S+)*)$`),
+		Syntax:  "(<option>=<value>)*",
+		Help:    "AMD SEV-SNP key derivation",
+		Fn:      kdfCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
+		Name:    "sev-vmpck",
+		Args:    1,
+		Pattern: regexp.MustCompile(`^sev-vmpck(?: ([0-3]))?$`),
+		Syntax:  "(<index>)?",
+		Help:    "AMD SEV-SNP VMPCK state/selection",
+		Fn:      vmpckCmd,
+	})
+
+	add(shell.Cmd{
+		Name:    "sev-pages",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^sev-pages(?: (grow|shrink)(?: (\d+))?)?$`),
+		Syntax:  "(grow <MB>|shrink)?",
+		Help:    "AMD SEV-SNP page state",
+		Fn:      pagesCmd,
+	})
+
+	add(shell.Cmd{
 		Name: "sev-tsc",
 		Help: "AMD SEV-SNP TSC information",
 		Fn:   tscCmd,
 	})
 }
 
-func reportVerify(report *sev.AttestationReport) {
-	if net.SocketFunc == nil {
-		log.Printf("Verification error, network unavailable")
+// reportData returns the attestation report REPORT_DATA, either a verifier
+// supplied nonce, the SHA-512 digest of a file or random bytes.
+func reportData(kind string, val string) (data []byte, err error) {
+	switch kind {
+	case "nonce":
+		if data, err = hex.DecodeString(val); err != nil {
+			return nil, fmt.Errorf("invalid nonce, %v", err)
+		}
+
+		if len(data) > attest.ReportDataSize {
+			return nil, fmt.Errorf("invalid nonce size (%d > %d)", len(data), attest.ReportDataSize)
+		}
+	case "file":
+		buf, err := readFile(val)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha512.Sum512(buf)
+		data = sum[:]
+	default:
+		data = make([]byte, attest.ReportDataSize)
+		rand.Read(data)
+	}
+
+	return
+}
+
+func reportJSON(report *sev.AttestationReport) (res string, err error) {
+	buf, err := attest.ReportJSON(report.Bytes())
+
+	if err != nil {
+		return "", fmt.Errorf("could not convert report, %v", err)
+	}
+
+	return string(buf), nil
+}
+
+func reportProto(report *sev.AttestationReport, certs []byte) (res string, err error) {
+	// hypervisor certificates are optional
+	chain, _ := attest.ParseCertTable(certs)
+
+	buf, err := attest.Attestation(report.Bytes(), chain)
+
+	if err != nil {
+		return "", fmt.Errorf("could not convert report, %v", err)
+	}
+
+	return fmt.Sprintf("%x", buf), nil
+}
+
+func reportCerts(buf *bytes.Buffer, certs []byte) {
+	chain, err := attest.ParseCertTable(certs)
+
+	if err != nil {
+		fmt.Fprintf(buf, "\nCertificates unavailable, %v\n", err)
+		return
+	}
+
+	for _, c := range []struct {
+		name string
+		der  []byte
+	}{
+		{"VCEK", chain.VcekCert},
+		{"VLEK", chain.VlekCert},
+		{"ASK", chain.AskCert},
+		{"ARK", chain.ArkCert},
+	} {
+		if len(c.der) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(buf, "\n%s:\n", c.name)
+		pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	}
+}
+
+func reportVerify(buf *bytes.Buffer, report *sev.AttestationReport, data []byte, certs []byte, path string, policyPath string) {
+	var err error
+	var p *policy.Policy
+
+	opts := &attest.Options{}
+	mode := "on-line"
+
+	if err = keyDigests(opts); err != nil {
+		fmt.Fprintf(buf, "\nVerification error, %v\n", err)
 		return
 	}
 
-	log.Printf("** requesting on-line report verification **")
+	fmt.Fprintf(buf, "\n")
 
-	go func() {
-		if err := verify.RawSnpReport(report.Bytes(), verify.DefaultOptions()); err != nil {
-			log.Printf("Verification error, %v", err)
-		} else {
-			log.Printf("Verification succeeded")
+	if len(policyPath) > 0 {
+		if p, err = readPolicy(policyPath); err != nil {
+			fmt.Fprintf(buf, "Verification error, %v\n", err)
+			return
 		}
-	}()
+
+		if err = p.Apply(opts); err != nil {
+			fmt.Fprintf(buf, "Verification error, %v\n", err)
+			return
+		}
+	}
+
+	if len(path) == 0 && len(certs) > 0 {
+		if opts.Chain, err = attest.ParseCertTable(certs); err == nil {
+			mode = "off-line, hypervisor certificates"
+		}
+	}
+
+	if len(path) > 0 {
+		chain, err := readFile(path)
+
+		if err != nil {
+			fmt.Fprintf(buf, "Verification error, %v\n", err)
+			return
+		}
+
+		if opts.Chain, err = attest.ParseChain(chain); err != nil {
+			fmt.Fprintf(buf, "Verification error, invalid chain, %v\n", err)
+			return
+		}
+
+		mode = "off-line"
+	} else if opts.Chain == nil && net.SocketFunc == nil {
+		fmt.Fprintf(buf, "Verification error, network unavailable\n")
+		return
+	}
+
+	log.Printf("** performing %s report verification **", mode)
+
+	res, err := attest.Verify(report.Bytes(), data, opts)
+
+	if err != nil {
+		fmt.Fprintf(buf, "Verification error, %v\n", err)
+		return
+	}
+
+	fmt.Fprintf(buf, "Verification .......: succeeded (%s)\n", mode)
+	fmt.Fprintf(buf, "Signer .............: %v\n", res.Signer)
+	fmt.Fprintf(buf, "Debug ..............: %v\n", res.Policy.Debug)
+	fmt.Fprintf(buf, "Current TCB ........: %+v\n", res.CurrentTCB)
+	fmt.Fprintf(buf, "Reported TCB .......: %+v\n", res.ReportedTCB)
+	fmt.Fprintf(buf, "Committed TCB ......: %+v\n", res.CommittedTCB)
+	fmt.Fprintf(buf, "ID Key .............: %s\n", keyStatus(opts.IDKeyDigests))
+	fmt.Fprintf(buf, "Author Key .........: %s\n", keyStatus(opts.AuthorKeyDigests))
+
+	if p == nil {
+		return
+	}
+
+	if err = p.Evaluate(res.Report); err != nil {
+		fmt.Fprintf(buf, "Appraisal ..........: failed (%s)\n", policyPath)
+
+		for _, v := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(buf, "  %s\n", v)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(buf, "Appraisal ..........: passed (%s)\n", policyPath)
+}
+
+// readPolicy reads an appraisal policy from the UEFI root volume.
+func readPolicy(path string) (p *policy.Policy, err error) {
+	buf, err := readFile(path)
+
+	if err != nil {
+		return
+	}
+
+	return policy.Parse(buf)
+}
+
+// keyDigests sets the expected ID and author key digests, if any.
+func keyDigests(opts *attest.Options) (err error) {
+	for _, k := range []struct {
+		val     string
+		digests *[][]byte
+	}{
+		{IDKeyDigest, &opts.IDKeyDigests},
+		{AuthorKeyDigest, &opts.AuthorKeyDigests},
+	} {
+		if len(k.val) == 0 {
+			continue
+		}
+
+		digest, err := hex.DecodeString(k.val)
+
+		if err != nil {
+			return fmt.Errorf("invalid key digest, %v", err)
+		}
+
+		*k.digests = append(*k.digests, digest)
+	}
+
+	return
+}
+
+func keyStatus(digests [][]byte) string {
+	if len(digests) == 0 {
+		return "unchecked"
+	}
+
+	return "allowed"
+}
+
+// flags formats a bitmap value along with its decoded flag names.
+func flags(val uint64, names []string) string {
+	if len(names) == 0 {
+		return fmt.Sprintf("%#x", val)
+	}
+
+	return fmt.Sprintf("%#x (%s)", val, strings.Join(names, " "))
+}
+
+// policyFlags formats a guest policy value along with its decoded fields.
+func policyFlags(val uint64) string {
+	p, err := attest.DecodePolicy(val)
+
+	if err != nil {
+		return fmt.Sprintf("%#x (%v)", val, err)
+	}
+
+	return fmt.Sprintf("%#x (ABI %d.%d %s)", val, p.ABIMajor, p.ABIMinor, strings.Join(p.Flags(), " "))
+}
+
+// platformInfoFlags formats a PLATFORM_INFO value along with its decoded
+// flags.
+func platformInfoFlags(val uint64) string {
+	info, err := attest.DecodePlatformInfo(val)
+
+	if err != nil {
+		return fmt.Sprintf("%#x (%v)", val, err)
+	}
+
+	return flags(val, info.Flags())
 }
 
 func sevCmd(_ *shell.Interface, _ []string) (res string, err error) {
@@ -95,6 +351,10 @@ func sevCmd(_ *shell.Interface, _ []string) (res string, err error) {
 		return
 	}
 
+	// SEV_STATUS MSR bits, from bit 2 onwards, match SEV_FEATURES
+	sevFeatures := x64.AMD64.MSR(sev.MSR_AMD_SEV_STATUS) >> 2
+	fmt.Fprintf(&buf, "SEV Features .......: %s\n", flags(sevFeatures, attest.DecodeSEVFeatures(sevFeatures).Flags()))
+
 	if x64.Console.Out == 0 {
 		return "", fmt.Errorf("EFI boot services not available")
 	}
@@ -117,7 +377,7 @@ func sevCmd(_ *shell.Interface, _ []string) (res string, err error) {
 
 	fmt.Fprintf(&buf, "Secrets Version ....: %d\n", s.Version)
 	fmt.Fprintf(&buf, "TSC Factor .........: %#x\n", s.TSCFactor)
-	fmt.Fprintf(&buf, "Launch Mitigations .: %#x\n", s.LaunchMitVector)
+	fmt.Fprintf(&buf, "Launch Mitigations .: %s\n", flags(s.LaunchMitVector, attest.DecodeMitigations(s.LaunchMitVector).Flags()))
 	fmt.Fprintf(&buf, "VMPCK0 .............: %#02x -- %#02x\n", s.VMPCK0[0], s.VMPCK0[31])
 	fmt.Fprintf(&buf, "VMPCK1 .............: %#02x -- %#02x\n", s.VMPCK1[0], s.VMPCK1[31])
 	fmt.Fprintf(&buf, "VMPCK2 .............: %#02x -- %#02x\n", s.VMPCK2[0], s.VMPCK2[31])
@@ -126,15 +386,21 @@ func sevCmd(_ *shell.Interface, _ []string) (res string, err error) {
 	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "vCPU ...............: %d\n", goos.ProcID())
 	fmt.Fprintf(&buf, "GHCB GPA ...........: %#x\n", x64.AMD64.MSR(sev.MSR_AMD_GHCB))
+	fmt.Fprintf(&buf, "GHCB GPA (unikernel): %#x\n", kvm.GHCBAddress(goos.ProcID()))
+
+	var hvFeatures uint64
 
-	hvFeatures, err := kvm.GHCB[goos.ProcID()].HypervisorFeatures()
+	err = kvm.WithGHCB(func(b *sev.GHCB) (err error) {
+		hvFeatures, err = b.HypervisorFeatures()
+		return
+	})
 
 	if err != nil {
 		fmt.Fprintf(&buf, " could not request hypervisor featuress, %v", err)
 		return
 	}
 
-	fmt.Fprintf(&buf, "Hypervisor Features : %#x\n", hvFeatures)
+	fmt.Fprintf(&buf, "Hypervisor Features : %s\n", flags(hvFeatures, attest.DecodeHypervisorFeatures(hvFeatures).Flags()))
 
 	return
 }
@@ -142,80 +408,196 @@ func sevCmd(_ *shell.Interface, _ []string) (res string, err error) {
 func attestationCmd(_ *shell.Interface, arg []string) (res string, err error) {
 	var buf bytes.Buffer
 	var report *sev.AttestationReport
+	var certs []byte
+
+	data, err := reportData(arg[3], arg[4])
 
-	if kvm.GHCB == nil {
-		return "", fmt.Errorf("GHCB not present")
+	if err != nil {
+		return
 	}
 
-	ghcb := kvm.GHCB[goos.ProcID()]
-	vmpck := kvm.Secrets.VMPCK0[:]
+	switch {
+	case arg[0] == "certs", arg[0] == "proto", arg[0] == "verify" && len(arg[1]) == 0:
+		// fetch hypervisor certificates, if available
+		if report, certs, err = kvm.ExtendedReport(data); err == nil {
+			break
+		}
 
-	data := make([]byte, 64)
-	rand.Read(data)
+		if arg[0] == "certs" {
+			return "", fmt.Errorf("could not get extended report, %v", err)
+		}
 
-	if report, err = ghcb.GetAttestationReport(data, vmpck, 0); err != nil {
-		return "", fmt.Errorf("could not get report, %v", err)
+		log.Printf("could not get extended report, %v", err)
+		fallthrough
+	default:
+		if report, err = kvm.Report(data); err != nil {
+			return "", fmt.Errorf("could not get report, %v", err)
+		}
 	}
 
-	if arg[0] == "raw" {
+	switch arg[0] {
+	case "raw":
 		return fmt.Sprintf("%x", report.Bytes()), nil
+	case "json":
+		return reportJSON(report)
+	case "proto":
+		return reportProto(report, certs)
 	}
 
 	fmt.Fprintf(&buf, "Version ............: %x\n", report.Version)
 	fmt.Fprintf(&buf, "VMPL ...............: %x\n", report.VMPL)
 	fmt.Fprintf(&buf, "SignatureAlgo ......: %x\n", report.SignatureAlgo)
+	fmt.Fprintf(&buf, "Policy .............: %s\n", policyFlags(report.Policy))
 	fmt.Fprintf(&buf, "CurrentTCB .........: %x\n", report.CurrentTCB)
+	fmt.Fprintf(&buf, "ReportData .........: %x\n", report.ReportData)
 	fmt.Fprintf(&buf, "Measurement ........: %x\n", report.Measurement)
 	fmt.Fprintf(&buf, "ReportedTCB ........: %x\n", report.ReportedTCB)
 	fmt.Fprintf(&buf, "CommittedTCB .......: %x\n", report.CommittedTCB)
-	fmt.Fprintf(&buf, "Launch  Mitigations : %#x\n", report.LaunchMitVector)
-	fmt.Fprintf(&buf, "Current Mitigations : %#x\n", report.CurrentMitVector)
+	fmt.Fprintf(&buf, "FamilyID ...........: %x\n", report.FamilyID)
+	fmt.Fprintf(&buf, "ImageID ............: %x\n", report.ImageID)
+	fmt.Fprintf(&buf, "IDKeyDigest ........: %x\n", report.IDKeyDigest)
+	fmt.Fprintf(&buf, "AuthorKeyDigest ....: %x\n", report.AuthorKeyDigest)
+	fmt.Fprintf(&buf, "AuthorKeyEn ........: %v\n", report.SignerInfo&1 != 0)
+	fmt.Fprintf(&buf, "PlatformInfo .......: %s\n", platformInfoFlags(report.PlatformInfo))
+	fmt.Fprintf(&buf, "Launch  Mitigations : %s\n", flags(report.LaunchMitVector, attest.DecodeMitigations(report.LaunchMitVector).Flags()))
+	fmt.Fprintf(&buf, "Current Mitigations : %s\n", flags(report.CurrentMitVector, attest.DecodeMitigations(report.CurrentMitVector).Flags()))
 	fmt.Fprintf(&buf, "SignatureR .........: %x\n", report.Signature[0:48])
 	fmt.Fprintf(&buf, "SignatureS .........: %x\n", report.Signature[72:72+48])
 
-	if arg[0] == "verify" {
-		reportVerify(report)
+	switch arg[0] {
+	case "certs":
+		reportCerts(&buf, certs)
+	case "verify":
+		reportVerify(&buf, report, data, certs, arg[1], arg[2])
 	}
 
 	return buf.String(), nil
 }
 
+// kdfPolicy parses key derivation options into a policy and HKDF label.
+func kdfPolicy(arg string) (policy *kvm.KeyPolicy, label string, err error) {
+	var n uint64
+	var mix bool
+
+	p := *kvm.DefaultKeyPolicy
+	policy = &p
+
+	for _, opt := range strings.Fields(arg) {
+		key, val, _ := strings.Cut(opt, "=")
+
+		switch key {
+		case "root":
+			switch val {
+			case "default":
+				policy.Root = kvm.KeyRootDefault
+			case "vcek":
+				policy.Root = kvm.KeyRootVCEK
+			case "vlek":
+				policy.Root = kvm.KeyRootVLEK
+			case "vmrk":
+				policy.Root = kvm.KeyRootVMRK
+			default:
+				return nil, "", fmt.Errorf("invalid root key %q", val)
+			}
+		case "fields":
+			policy.Fields, err = kvm.ParseKeyFields(val)
+		case "vmpl":
+			n, err = strconv.ParseUint(val, 0, 32)
+			policy.VMPL = uint32(n)
+		case "svn":
+			n, err = strconv.ParseUint(val, 0, 32)
+			policy.GuestSVN = uint32(n)
+		case "tcb":
+			policy.TCBVersion, err = strconv.ParseUint(val, 0, 64)
+		case "mit":
+			policy.LaunchMitVector, err = strconv.ParseUint(val, 0, 64)
+		case "mix":
+			mix = true
+			policy.Mix, err = strconv.ParseUint(val, 0, 64)
+		case "label":
+			label = val
+		default:
+			return nil, "", fmt.Errorf("invalid option %q", key)
+		}
+
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid %s, %v", key, err)
+		}
+	}
+
+	// the mix value only applies to HKDF derived keys
+	if mix && len(label) == 0 {
+		return nil, "", fmt.Errorf("mix requires label")
+	}
+
+	return
+}
+
 func kdfCmd(_ *shell.Interface, arg []string) (res string, err error) {
 	var key []byte
 
-	if kvm.GHCB == nil {
-		return "", fmt.Errorf("GHCB not present")
-	}
+	policy, label, err := kdfPolicy(arg[0])
 
-	// Perform key derivation from the physical CPU keys, bound to the
-	// Guest Policy and VM identity.
-	req := &sev.KeyRequest{
-		KeySelect:        sev.RootKeySelVCEK,
-		GuestFieldSelect: sev.GuestSVN | sev.Measurement | sev.GuestPolicy,
+	if err != nil {
+		return
 	}
 
-	ghcb := kvm.GHCB[goos.ProcID()]
-	vmpck := kvm.Secrets.VMPCK0[:]
+	if len(label) > 0 {
+		key, err = kvm.DeriveKey(policy, label)
+	} else {
+		key, err = kvm.DeriveGuestKey(policy)
+	}
 
-	if key, err = ghcb.DeriveKey(req, vmpck, 0); err != nil {
+	if err != nil {
 		return "", fmt.Errorf("could not derive key, %v", err)
 	}
 
 	return fmt.Sprintf("%x\n", key), nil
 }
 
-func tscCmd(_ *shell.Interface, arg []string) (res string, err error) {
+func vmpckCmd(_ *shell.Interface, arg []string) (res string, err error) {
 	var buf bytes.Buffer
-	var tsc *sev.TSCInfo
 
-	if kvm.GHCB == nil {
-		return "", fmt.Errorf("GHCB not present")
+	if len(arg[0]) > 0 {
+		index, _ := strconv.Atoi(arg[0])
+
+		if err = kvm.SetVMPCK(index); err != nil {
+			return
+		}
+	}
+
+	state := kvm.VMPCK()
+
+	if len(state) == 0 {
+		return "", fmt.Errorf("AMD SEV-SNP secrets not available")
+	}
+
+	for _, k := range state {
+		fmt.Fprintf(&buf, "VMPCK%d .............: seq:%d requests:%d", k.Index, k.Seq, k.Requests)
+
+		if k.Selected {
+			fmt.Fprintf(&buf, " selected")
+		}
+
+		if k.Pending {
+			fmt.Fprintf(&buf, " pending")
+		}
+
+		if k.Disabled {
+			fmt.Fprintf(&buf, " disabled (%v)", k.Err)
+		}
+
+		fmt.Fprintf(&buf, "\n")
 	}
 
-	ghcb := kvm.GHCB[goos.ProcID()]
-	vmpck := kvm.Secrets.VMPCK0[:]
+	return buf.String(), nil
+}
+
+func tscCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+	var tsc *sev.TSCInfo
 
-	if tsc, err = ghcb.TSCInfo(vmpck, 0); err != nil {
+	if tsc, err = kvm.TSCInfo(); err != nil {
 		return "", fmt.Errorf("could not request TSC, %v", err)
 	}
 
@@ -225,3 +607,67 @@ func tscCmd(_ *shell.Interface, arg []string) (res string, err error) {
 
 	return buf.String(), nil
 }
+
+func pagesCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+	var private, shared, large int
+
+	switch arg[0] {
+	case "grow":
+		size, _ := strconv.Atoi(arg[1])
+
+		if _, err = kvm.GrowShared(size << 20); err != nil {
+			return "", fmt.Errorf("could not grow shared memory, %v", err)
+		}
+	case "shrink":
+		for _, r := range kvm.SharedRegions() {
+			if err = kvm.ShrinkShared(r); err != nil {
+				log.Printf("could not release shared region %#x, %v", r.Start(), err)
+			}
+		}
+	}
+
+	ranges, err := kvm.PageState()
+
+	if err != nil {
+		return "", fmt.Errorf("page state not available, %v", err)
+	}
+
+	for _, r := range ranges {
+		state := "private"
+
+		if r.Shared {
+			state = "shared"
+			shared += r.Pages()
+		} else {
+			private += r.Pages()
+		}
+
+		if r.Large {
+			large += r.Pages() / 512
+			state += " (2M)"
+		}
+
+		fmt.Fprintf(&buf, "%#016x-%#016x %s\n", r.Start, r.End, state)
+	}
+
+	fmt.Fprintf(&buf, "\n")
+	fmt.Fprintf(&buf, "Private pages ......: %d\n", private)
+	fmt.Fprintf(&buf, "Shared pages .......: %d\n", shared)
+	fmt.Fprintf(&buf, "2M pages ...........: %d\n", large)
+	fmt.Fprintf(&buf, "Shared regions .....: %d (runtime)\n", len(kvm.SharedRegions()))
+
+	names, stats := kvm.BounceStats()
+
+	if len(stats) > 0 {
+		fmt.Fprintf(&buf, "\nBounce buffers (%d bytes)\n", kvm.BounceSize)
+		fmt.Fprintf(&buf, "%-16s %10s %10s %10s %8s %8s %12s %12s\n", "client", "in use", "peak", "quota", "maps", "failures", "to device", "from device")
+	}
+
+	for i, s := range stats {
+		fmt.Fprintf(&buf, "%-16s %10d %10d %10d %8d %8d %12d %12d\n",
+			names[i], s.InUse, s.Peak, s.Quota, s.Maps, s.Failures, s.BytesToDevice, s.BytesFromDevice)
+	}
+
+	return buf.String(), nil
+}
@@ -22,7 +22,7 @@ import (
 )
 
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "smp",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^smp (\d+)$`),
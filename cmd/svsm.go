@@ -0,0 +1,117 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/go-boot/shell"
+	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+	"github.com/usbarmory/tamago-sev-example/internal/svsm"
+)
+
+func init() {
+	if !sev.Features(x64.AMD64).SEV.SNP {
+		return
+	}
+
+	add(shell.Cmd{
+		Name:    "svsm",
+		Args:    2,
+		Pattern: regexp.MustCompile(`^svsm(?: (attest)(?: nonce (\S+))?)?$`),
+		Syntax:  "(attest (nonce <hex>)?)?",
+		Help:    "AMD SEV-SNP SVSM information/attestation",
+		Fn:      svsmCmd,
+	})
+}
+
+func svsmProtocol(buf *bytes.Buffer, name string, protocol uint32) {
+	minVersion, maxVersion, err := kvm.SVSMQueryProtocol(protocol, 1)
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(buf, "%s: %v\n", name, err)
+	case maxVersion == 0:
+		fmt.Fprintf(buf, "%s: unsupported\n", name)
+	default:
+		fmt.Fprintf(buf, "%s: v%d-v%d\n", name, minVersion, maxVersion)
+	}
+}
+
+func svsmAttest(buf *bytes.Buffer, val string) (err error) {
+	kind := ""
+
+	if len(val) > 0 {
+		kind = "nonce"
+	}
+
+	nonce, err := reportData(kind, val)
+
+	if err != nil {
+		return
+	}
+
+	a, err := kvm.SVSMAttest(nonce)
+
+	if err != nil {
+		return fmt.Errorf("could not get SVSM attestation, %v", err)
+	}
+
+	report := &sev.AttestationReport{}
+
+	if _, err = binary.Decode(a.Report, binary.LittleEndian, report); err != nil {
+		return fmt.Errorf("could not parse report, %v", err)
+	}
+
+	sum := sha512.Sum512(append(nonce, a.Manifest...))
+
+	fmt.Fprintf(buf, "\n")
+	fmt.Fprintf(buf, "Nonce ..............: %x\n", nonce)
+	fmt.Fprintf(buf, "VMPL ...............: %x\n", report.VMPL)
+	fmt.Fprintf(buf, "ReportData .........: %x\n", report.ReportData)
+	fmt.Fprintf(buf, "Measurement ........: %x\n", report.Measurement)
+	fmt.Fprintf(buf, "Manifest ...........: %x\n", a.Manifest)
+	fmt.Fprintf(buf, "Certificates .......: %d bytes\n", len(a.Certificates))
+	fmt.Fprintf(buf, "Manifest binding ...: %v\n", bytes.Equal(report.ReportData[:], sum[:]))
+
+	return
+}
+
+func svsmCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	s := kvm.SVSMInfo()
+
+	fmt.Fprintf(&buf, "VMPL ...............: %d\n", kvm.VMPL())
+
+	if s == nil {
+		fmt.Fprintf(&buf, "SVSM ...............: not present\n")
+		return buf.String(), nil
+	}
+
+	fmt.Fprintf(&buf, "SVSM Base ..........: %#x (%d bytes)\n", s.Base, s.Size)
+	fmt.Fprintf(&buf, "SVSM Calling Area ..: %#x\n", s.CAA)
+	fmt.Fprintf(&buf, "SVSM Max Version ...: %d\n", s.MaxVersion)
+
+	svsmProtocol(&buf, "Core Protocol ......", svsm.SVSM_CORE_PROTOCOL)
+	svsmProtocol(&buf, "Attest Protocol ....", svsm.SVSM_ATTEST_PROTOCOL)
+
+	if arg[0] == "attest" {
+		if err = svsmAttest(&buf, arg[1]); err != nil {
+			return
+		}
+	}
+
+	return buf.String(), nil
+}
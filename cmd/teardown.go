@@ -0,0 +1,39 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"net"
+
+	"github.com/usbarmory/tamago/soc/intel/pci"
+
+	"github.com/usbarmory/tamago-sev-example/internal/teardown"
+)
+
+// PCI Command register bits
+const pciBusMaster = 1 << 2
+
+// disableBusMaster prevents the argument PCI device from issuing any further
+// DMA transaction.
+func disableBusMaster(d *pci.Device) error {
+	if d == nil {
+		return errors.New("invalid device")
+	}
+
+	d.Write(0, pci.Command, d.Read(0, pci.Command)&0xffff&^pciBusMaster)
+
+	return nil
+}
+
+// stopNetwork registers a teardown hook which detaches the network stack from
+// the Go runtime and stops the network interface with the argument function.
+func stopNetwork(name string, stop func() error) {
+	teardown.Register("stop "+name, teardown.StageDevices, func() error {
+		net.SocketFunc = nil
+		return stop()
+	})
+}
@@ -0,0 +1,254 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/go-boot/shell"
+	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+	"github.com/usbarmory/tamago-sev-example/internal/tpm"
+)
+
+// PCR allocation, matching GRUB measured boot conventions
+const (
+	pcrCommands = 8
+	pcrFiles    = 9
+)
+
+var (
+	vtpm     *tpm.TPM
+	vtpmErr  error
+	vtpmOnce sync.Once
+	eventLog tpm.EventLog
+)
+
+func init() {
+	if !sev.Features(x64.AMD64).SEV.SNP {
+		return
+	}
+
+	add(shell.Cmd{
+		Name:    "tpm",
+		Args:    3,
+		Pattern: regexp.MustCompile(`^tpm(?: (log|quote)(?: nonce (\S+))?(?: pcr ([\d,]+))?)?$`),
+		Syntax:  "(log|quote (nonce <hex>)? (pcr <n,...>)?)?",
+		Help:    "SVSM vTPM PCRs/event log/quote",
+		Fn:      tpmCmd,
+	})
+}
+
+// measureCommand wraps a command handler to measure, when a vTPM is present,
+// each invocation before its execution, regardless of the shell interface
+// (e.g. UART or SSH) it originates from.
+//
+// The measured command line is the command name followed by its non-empty
+// arguments.
+func measureCommand(c shell.Cmd) shell.Cmd {
+	fn := c.Fn
+
+	c.Fn = func(console *shell.Interface, arg []string) (string, error) {
+		line := []string{c.Name}
+
+		for _, a := range arg {
+			if len(a) > 0 {
+				line = append(line, a)
+			}
+		}
+
+		entry := strings.Join(line, " ")
+		measure(pcrCommands, "command", entry, []byte(entry))
+
+		return fn(console, arg)
+	}
+
+	return c
+}
+
+func openTPM() (*tpm.TPM, error) {
+	vtpmOnce.Do(func() {
+		t, err := kvm.OpenVTPM()
+
+		if err != nil {
+			vtpmErr = err
+			return
+		}
+
+		vtpm = &tpm.TPM{Transport: t}
+
+		if err = vtpm.Startup(); err != nil {
+			vtpm = nil
+			vtpmErr = fmt.Errorf("could not start vTPM, %v", err)
+		}
+	})
+
+	return vtpm, vtpmErr
+}
+
+// measure extends, when a vTPM is present, a PCR with the SHA-256 digest of
+// the argument data and records the event.
+func measure(pcr int, typ string, desc string, data []byte) {
+	t, err := openTPM()
+
+	if err != nil {
+		return
+	}
+
+	if err = eventLog.Measure(t, pcr, typ, desc, data); err != nil {
+		log.Printf("could not measure %s %s, %v", typ, desc, err)
+	}
+}
+
+func tpmPCRs(buf *bytes.Buffer, t *tpm.TPM) (err error) {
+	for i := 0; i < tpm.NumPCR; i++ {
+		digest, err := t.Read(i)
+
+		if err != nil {
+			return fmt.Errorf("could not read PCR%d, %v", i, err)
+		}
+
+		fmt.Fprintf(buf, "PCR%-2d ..............: %x\n", i, digest)
+	}
+
+	return
+}
+
+func tpmLog(buf *bytes.Buffer, t *tpm.TPM) (err error) {
+	replay := eventLog.Replay()
+
+	for _, e := range eventLog.Events() {
+		fmt.Fprintf(buf, "%2d %x %-7s %s\n", e.PCR, e.Digest, e.Type, e.Description)
+	}
+
+	fmt.Fprintf(buf, "\n")
+
+	for _, pcr := range []int{pcrCommands, pcrFiles} {
+		digest, err := t.Read(pcr)
+
+		if err != nil {
+			return fmt.Errorf("could not read PCR%d, %v", pcr, err)
+		}
+
+		fmt.Fprintf(buf, "PCR%-2d replay .......: %v\n", pcr, bytes.Equal(digest, replay[pcr][:]))
+	}
+
+	return
+}
+
+func tpmQuote(buf *bytes.Buffer, t *tpm.TPM, nonce string, sel string) (err error) {
+	pcrs := []int{pcrCommands, pcrFiles}
+
+	if len(sel) > 0 {
+		if pcrs, err = parsePCRs(sel); err != nil {
+			return
+		}
+	}
+
+	kind := ""
+
+	if len(nonce) > 0 {
+		kind = "nonce"
+	}
+
+	data, err := reportData(kind, nonce)
+
+	if err != nil {
+		return
+	}
+
+	handle, pub, err := t.CreateAK()
+
+	if err != nil {
+		return fmt.Errorf("could not create AK, %v", err)
+	}
+
+	defer t.Flush(handle)
+
+	ak, err := pub.ECDH()
+
+	if err != nil {
+		return fmt.Errorf("invalid AK, %v", err)
+	}
+
+	q, err := t.Quote(handle, data, pcrs)
+
+	if err != nil {
+		return fmt.Errorf("could not get quote, %v", err)
+	}
+
+	// bind the quote to an attestation report
+	sum := sha512.Sum512(q.Attest)
+	report, err := kvm.Report(sum[:])
+
+	if err != nil {
+		return fmt.Errorf("could not get report, %v", err)
+	}
+
+	fmt.Fprintf(buf, "PCRs ...............: %v\n", pcrs)
+	fmt.Fprintf(buf, "Nonce ..............: %x\n", data)
+	fmt.Fprintf(buf, "AK .................: %x\n", ak.Bytes())
+	fmt.Fprintf(buf, "Quote ..............: %x\n", q.Attest)
+	fmt.Fprintf(buf, "SignatureR .........: %x\n", q.R)
+	fmt.Fprintf(buf, "SignatureS .........: %x\n", q.S)
+	fmt.Fprintf(buf, "Signature valid ....: %v\n", q.Verify(pub))
+	fmt.Fprintf(buf, "\n")
+	fmt.Fprintf(buf, "ReportData .........: %x\n", report.ReportData)
+	fmt.Fprintf(buf, "Measurement ........: %x\n", report.Measurement)
+	fmt.Fprintf(buf, "Report .............: %x\n", report.Bytes())
+
+	return
+}
+
+func parsePCRs(sel string) (pcrs []int, err error) {
+	for _, s := range strings.Split(sel, ",") {
+		pcr, err := strconv.Atoi(s)
+
+		if err != nil || pcr >= tpm.NumPCR {
+			return nil, errors.New("invalid PCR selection")
+		}
+
+		pcrs = append(pcrs, pcr)
+	}
+
+	return
+}
+
+func tpmCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	var buf bytes.Buffer
+
+	t, err := openTPM()
+
+	if err != nil {
+		return "", fmt.Errorf("vTPM not available, %v", err)
+	}
+
+	switch arg[0] {
+	case "log":
+		err = tpmLog(&buf, t)
+	case "quote":
+		err = tpmQuote(&buf, t, arg[1], arg[2])
+	default:
+		err = tpmPCRs(&buf, t)
+	}
+
+	if err != nil {
+		return
+	}
+
+	return buf.String(), nil
+}
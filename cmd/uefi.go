@@ -20,6 +20,9 @@ import (
 	"github.com/usbarmory/go-boot/shell"
 	"github.com/usbarmory/go-boot/uefi"
 	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+	"github.com/usbarmory/tamago-sev-example/internal/teardown"
 )
 
 const (
@@ -27,14 +30,18 @@ const (
 	maxVendorSize = 64
 )
 
+// APCreation selects unikernel AP creation (see kvm.InitSMP), performed once
+// EFI boot services are exited.
+var APCreation bool
+
 func init() {
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name: "uefi",
 		Help: "UEFI information",
 		Fn:   uefiCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "cat",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^cat (.*)`),
@@ -43,7 +50,7 @@ func init() {
 		Fn:      catCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "ls",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^ls(?: (\S+))?$`),
@@ -52,7 +59,7 @@ func init() {
 		Fn:      lsCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "stat",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^stat (.*)`),
@@ -61,7 +68,7 @@ func init() {
 		Fn:      statCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "reset",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^reset(?: (cold|warm))?$`),
@@ -70,7 +77,7 @@ func init() {
 		Fn:      resetCmd,
 	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "halt,shutdown",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^(halt|shutdown)$`),
@@ -78,13 +85,13 @@ func init() {
 		Fn:      shutdownCmd,
 	})
 
-	//shell.Add(shell.Cmd{
-	//	Name: "terminate",
-	//	Help: "exit EFI Boot Services",
-	//	Fn:   terminateCmd,
-	//})
+	add(shell.Cmd{
+		Name: "terminate",
+		Help: "exit EFI Boot Services",
+		Fn:   terminateCmd,
+	})
 
-	shell.Add(shell.Cmd{
+	add(shell.Cmd{
 		Name:    "efivar",
 		Args:    1,
 		Pattern: regexp.MustCompile(`^efivar(?: (verbose))?$`),
@@ -118,22 +125,33 @@ func uefiCmd(_ *shell.Interface, _ []string) (res string, err error) {
 	return buf.String(), err
 }
 
-func catCmd(_ *shell.Interface, arg []string) (res string, err error) {
+func readFile(path string) (buf []byte, err error) {
 	if x64.Console.Out == 0 {
-		return "", fmt.Errorf("EFI boot services not available")
+		return nil, fmt.Errorf("EFI boot services not available")
 	}
 
 	root, err := x64.UEFI.Root()
 
 	if err != nil {
-		return "", fmt.Errorf("could not open root volume, %v", err)
+		return nil, fmt.Errorf("could not open root volume, %v", err)
 	}
 
-	arg[0] = strings.ReplaceAll(arg[0], `\`, `/`)
-	buf, err := fs.ReadFile(root, arg[0])
+	path = strings.ReplaceAll(path, `\`, `/`)
+
+	if buf, err = fs.ReadFile(root, path); err != nil {
+		return nil, fmt.Errorf("could not read file, %v", err)
+	}
+
+	measure(pcrFiles, "file", path, buf)
+
+	return
+}
+
+func catCmd(_ *shell.Interface, arg []string) (res string, err error) {
+	buf, err := readFile(arg[0])
 
 	if err != nil {
-		return "", fmt.Errorf("could not read file, %v", err)
+		return
 	}
 
 	return string(buf), nil
@@ -236,6 +254,10 @@ func resetCmd(_ *shell.Interface, arg []string) (_ string, err error) {
 		resetType = uefi.EfiResetShutdown
 	}
 
+	if err = teardown.Run(); err != nil {
+		log.Printf("teardown incomplete, %v", err)
+	}
+
 	log.Printf("performing system reset type %d", resetType)
 	err = x64.UEFI.Runtime.ResetSystem(resetType)
 
@@ -274,6 +296,19 @@ func terminateCmd(_ *shell.Interface, _ []string) (_ string, err error) {
 	// trap CPU exceptions
 	x64.AMD64.EnableExceptions()
 
+	// replace OVMF #VC handler
+	if kvm.GHCB != nil {
+		if err = kvm.EnableVC(); err != nil {
+			return "", fmt.Errorf("could not enable #VC handler, %v", err)
+		}
+	}
+
+	if APCreation {
+		if err = kvm.InitSMP(-1); err != nil {
+			return "", fmt.Errorf("could not create APs, %v", err)
+		}
+	}
+
 	return
 }
 
@@ -8,8 +8,20 @@ package goos
 import "unsafe"
 
 // We use our own overlay, instead of github.com/usbarmory/tamago/goos, to
-// override StackSystem as this is required to accomodate OVMF #VC handler
-// stack usage.
+// override StackSystem as #VC handlers run on the interrupted stack, which
+// can be any goroutine stack.
+//
+// The unikernel #VC handler (see kvm.EnableVC) uses at most 1248 bytes:
+//
+//	 56  exception frame, error code and 16-byte alignment
+//	120  general purpose registers
+//	256  SSE registers
+//	 16  handleVC argument and return address
+//	800  handleVC nosplit chain (bounded by the linker nosplit limit)
+//
+// While EFI boot services are running the OVMF #VC handler, whose stack
+// usage is not statically bounded, can also be invoked, the remaining 2848
+// bytes are left as margin for it and are therefore not a guarantee.
 const (
 	ArenaBaseOffset     = 0
 	HeapAddrBits        = 40
@@ -0,0 +1,248 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package bounce implements bounce buffers, allowing drivers to keep data in
+// private (encrypted) memory and copy it to shared (unencrypted) memory only
+// while a device transfer is in flight.
+//
+// Shared buffers are cleared as soon as they are unmapped, each driver is
+// subject to a quota on the amount of shared memory it can hold.
+package bounce
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/usbarmory/tamago/dma"
+)
+
+// Direction represents a transfer direction.
+type Direction int
+
+// Transfer directions
+const (
+	// ToDevice copies private data to the shared buffer when mapped.
+	ToDevice Direction = 1 << iota
+	// FromDevice copies shared data to the private buffer when synced or
+	// unmapped.
+	FromDevice
+	// Bidirectional combines both directions.
+	Bidirectional = ToDevice | FromDevice
+)
+
+// ErrQuota is returned when a mapping would exceed the client quota.
+var ErrQuota = errors.New("quota exceeded")
+
+// Stats represents bounce buffer statistics.
+type Stats struct {
+	// Maps is the number of successful mappings.
+	Maps uint64
+	// Failures is the number of failed mappings.
+	Failures uint64
+	// BytesToDevice is the number of bytes copied to shared memory.
+	BytesToDevice uint64
+	// BytesFromDevice is the number of bytes copied from shared memory.
+	BytesFromDevice uint64
+	// InUse is the shared memory currently held.
+	InUse int
+	// Peak is the maximum shared memory held.
+	Peak int
+	// Quota is the maximum shared memory allowed, 0 for unlimited.
+	Quota int
+}
+
+// Pool represents a shared memory bounce buffer pool.
+type Pool struct {
+	sync.Mutex
+
+	// Region is the shared DMA region backing bounce buffers.
+	Region *dma.Region
+
+	clients map[string]*Client
+}
+
+// Client represents a driver using a bounce buffer pool.
+type Client struct {
+	// Name is the client identifier.
+	Name string
+
+	pool  *Pool
+	stats Stats
+}
+
+// Buffer represents a bounce buffer mapping.
+type Buffer struct {
+	// Addr is the shared buffer address, to be passed to the device.
+	Addr uint
+
+	client  *Client
+	dir     Direction
+	private []byte
+	shared  []byte
+	synced  bool
+}
+
+// NewPool returns a bounce buffer pool backed by the argument shared region.
+func NewPool(r *dma.Region) *Pool {
+	return &Pool{
+		Region:  r,
+		clients: make(map[string]*Client),
+	}
+}
+
+// Client returns the pool client for the argument name, creating it if
+// necessary, with the argument quota (0 for unlimited) applied.
+func (p *Pool) Client(name string, quota int) *Client {
+	p.Lock()
+	defer p.Unlock()
+
+	c, ok := p.clients[name]
+
+	if !ok {
+		c = &Client{
+			Name: name,
+			pool: p,
+		}
+
+		p.clients[name] = c
+	}
+
+	c.stats.Quota = quota
+
+	return c
+}
+
+// Stats returns the statistics of all pool clients, sorted by name.
+func (p *Pool) Stats() (names []string, stats []Stats) {
+	p.Lock()
+	defer p.Unlock()
+
+	for name := range p.clients {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		stats = append(stats, p.clients[name].stats)
+	}
+
+	return
+}
+
+// available returns whether the argument region has a free block suitable for
+// a reservation of the argument size and alignment, as [dma.Region.Reserve]
+// panics when exhausted.
+func available(r *dma.Region, size int, align int) bool {
+	if align == 0 {
+		align = dma.DefaultAlignment
+	}
+
+	for addr, n := range r.FreeBlocks() {
+		pad := -addr & uint(align-1)
+
+		if n >= uint(size)+pad {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stats returns the client statistics.
+func (c *Client) Stats() Stats {
+	c.pool.Lock()
+	defer c.pool.Unlock()
+
+	return c.stats
+}
+
+// Map reserves a shared buffer, with the argument alignment (0 for default),
+// for the argument private buffer, its content is copied to the shared buffer
+// for [ToDevice] transfers.
+func (c *Client) Map(buf []byte, align int, dir Direction) (b *Buffer, err error) {
+	p := c.pool
+
+	if len(buf) == 0 || align < 0 || align&(align-1) != 0 {
+		return nil, errors.New("invalid buffer")
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if c.stats.Quota > 0 && c.stats.InUse+len(buf) > c.stats.Quota {
+		c.stats.Failures += 1
+		return nil, fmt.Errorf("%s: %w (%d/%d)", c.Name, ErrQuota, c.stats.InUse, c.stats.Quota)
+	}
+
+	if !available(p.Region, len(buf), align) {
+		c.stats.Failures += 1
+		return nil, fmt.Errorf("%s: shared memory exhausted", c.Name)
+	}
+
+	addr, shared := p.Region.Reserve(len(buf), align)
+
+	b = &Buffer{
+		Addr:    addr,
+		client:  c,
+		dir:     dir,
+		private: buf,
+		shared:  shared,
+	}
+
+	if dir&ToDevice != 0 {
+		copy(shared, buf)
+		c.stats.BytesToDevice += uint64(len(buf))
+	} else {
+		// do not leak previous pool content to the device
+		clear(shared)
+	}
+
+	c.stats.Maps += 1
+	c.stats.InUse += len(buf)
+	c.stats.Peak = max(c.stats.Peak, c.stats.InUse)
+
+	return
+}
+
+// Sync copies, for [FromDevice] transfers, the shared buffer content to the
+// private buffer.
+func (b *Buffer) Sync() {
+	if b.shared == nil || b.dir&FromDevice == 0 {
+		return
+	}
+
+	copy(b.private, b.shared)
+	b.synced = true
+
+	b.client.pool.Lock()
+	b.client.stats.BytesFromDevice += uint64(len(b.shared))
+	b.client.pool.Unlock()
+}
+
+// Unmap synchronizes, unless already done with [Buffer.Sync], clears and
+// releases the shared buffer.
+func (b *Buffer) Unmap() {
+	if b.shared == nil {
+		return
+	}
+
+	if !b.synced {
+		b.Sync()
+	}
+
+	clear(b.shared)
+
+	c := b.client
+	c.pool.Lock()
+	defer c.pool.Unlock()
+
+	c.pool.Region.Release(b.Addr)
+	c.stats.InUse -= len(b.shared)
+
+	b.shared = nil
+}
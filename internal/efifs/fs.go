@@ -0,0 +1,252 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package efifs implements write access to the EFI image root volume, which
+// is not supported by the go-boot read-only [uefi.FS] interface.
+//
+// This package is only meant to be used before EFI Boot Services are exited.
+package efifs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/go-boot/uefi"
+	"github.com/usbarmory/go-boot/uefi/x64"
+)
+
+// EFI_FILE_PROTOCOL function offsets
+const (
+	fileOpen    = 0x08
+	fileClose   = 0x10
+	fileDelete  = 0x18
+	fileWrite   = 0x28
+	fileGetInfo = 0x40
+	fileSetInfo = 0x48
+	fileFlush   = 0x50
+)
+
+// EFI_FILE_INFO field offsets
+const fileInfoName = 0x50
+
+// temporary file suffix used while replacing files
+const tmpSuffix = ".tmp"
+
+// EFI_SIMPLE_FILE_SYSTEM_PROTOCOL function offsets
+const openVolume = 0x08
+
+// EFI_LOADED_IMAGE_PROTOCOL field offsets
+const deviceHandle = 0x18
+
+var (
+	EFI_LOADED_IMAGE_PROTOCOL_GUID       = uefi.MustParseGUID("5b1b31a1-9562-11d2-8e3f-00a0c969723b")
+	EFI_SIMPLE_FILE_SYSTEM_PROTOCOL_GUID = uefi.MustParseGUID("964e5b22-6459-11d2-8e39-00a0c969723b")
+	EFI_FILE_INFO_ID                     = uefi.MustParseGUID("09576e92-6d3f-11d2-8e39-00a0c969723b")
+)
+
+// The EFI service trampoline is shared with the go-boot uefi package to
+// serialize all firmware calls.
+//
+//go:linkname callService github.com/usbarmory/go-boot/uefi.callService
+func callService(fn uint64, args []uint64) (status uint64)
+
+// read64 reads a 64-bit value from physical memory.
+func read64(addr uint64) (val uint64, err error) {
+	if addr == 0 {
+		return 0, errors.New("invalid address")
+	}
+
+	r, err := dma.NewRegion(uint(addr), 8, false)
+
+	if err != nil {
+		return
+	}
+
+	ptr, buf := r.Reserve(8, 0)
+	defer r.Release(ptr)
+
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// call invokes the EFI service at the argument protocol function offset.
+func call(protocol uint64, off uint64, args ...uint64) (err error) {
+	fn, err := read64(protocol + off)
+
+	if err != nil {
+		return
+	}
+
+	if status := callService(fn, args); status != uefi.EFI_SUCCESS {
+		return fmt.Errorf("EFI_STATUS error %#x", status)
+	}
+
+	return
+}
+
+func ptr[T any](v *T) uint64 {
+	return uint64(uintptr(unsafe.Pointer(v)))
+}
+
+func toUTF16(s string) (buf []uint16) {
+	return append(utf16.Encode([]rune(s)), 0)
+}
+
+// root opens the EFI image root volume, the returned file protocol instance
+// must be closed after use.
+func root() (volume uint64, err error) {
+	if x64.Console.Out == 0 {
+		return 0, errors.New("EFI boot services not available")
+	}
+
+	boot := x64.UEFI.Boot
+
+	image, err := boot.HandleProtocol(x64.UEFI.ImageHandle(), EFI_LOADED_IMAGE_PROTOCOL_GUID)
+
+	if err != nil {
+		return
+	}
+
+	device, err := read64(image + deviceHandle)
+
+	if err != nil {
+		return
+	}
+
+	sfs, err := boot.HandleProtocol(device, EFI_SIMPLE_FILE_SYSTEM_PROTOCOL_GUID)
+
+	if err != nil {
+		return
+	}
+
+	err = call(sfs, openVolume, sfs, ptr(&volume))
+
+	return
+}
+
+// open opens a file on the argument volume.
+func open(volume uint64, name string, mode uint64) (file uint64, err error) {
+	name = strings.ReplaceAll(name, `/`, `\`)
+	fileName := toUTF16(name)
+
+	err = call(volume, fileOpen, volume, ptr(&file), ptr(&fileName[0]), mode, 0)
+
+	return
+}
+
+// write writes data to the argument file.
+func write(file uint64, data []byte) (err error) {
+	for len(data) > 0 {
+		size := uint64(len(data))
+
+		if err = call(file, fileWrite, file, ptr(&size), ptr(&data[0])); err != nil {
+			return
+		}
+
+		if size == 0 {
+			return errors.New("short write")
+		}
+
+		data = data[size:]
+	}
+
+	return call(file, fileFlush, file)
+}
+
+// rename renames the argument file, the new name is relative to the volume
+// root.
+func rename(file uint64, name string) (err error) {
+	var size uint64
+
+	fn, err := read64(file + fileGetInfo)
+
+	if err != nil {
+		return
+	}
+
+	// query the required EFI_FILE_INFO buffer size
+	status := callService(fn, []uint64{file, ptr(&EFI_FILE_INFO_ID), ptr(&size), 0})
+
+	if status&0xff != uefi.EFI_BUFFER_TOO_SMALL || size < fileInfoName {
+		return fmt.Errorf("EFI_STATUS error %#x", status)
+	}
+
+	info := make([]byte, size)
+
+	if err = call(file, fileGetInfo, file, ptr(&EFI_FILE_INFO_ID), ptr(&size), ptr(&info[0])); err != nil {
+		return
+	}
+
+	// replace the file name, sizing the structure accordingly
+	info = info[:fileInfoName]
+
+	for _, c := range toUTF16(`\` + strings.ReplaceAll(strings.TrimLeft(name, `/\`), `/`, `\`)) {
+		info = binary.LittleEndian.AppendUint16(info, c)
+	}
+
+	size = uint64(len(info))
+	binary.LittleEndian.PutUint64(info, size)
+
+	return call(file, fileSetInfo, file, ptr(&EFI_FILE_INFO_ID), size, ptr(&info[0]))
+}
+
+// WriteFile writes data to the named file on the EFI image root volume,
+// creating it if necessary. Any existing file content is replaced.
+//
+// Data is first written to a temporary file, which replaces the named one
+// only once complete, so that failed writes do not affect existing content.
+func WriteFile(name string, data []byte) (err error) {
+	volume, err := root()
+
+	if err != nil {
+		return fmt.Errorf("could not open root volume, %v", err)
+	}
+
+	defer call(volume, fileClose, volume)
+
+	mode := uint64(uefi.EFI_FILE_MODE_READ | uefi.EFI_FILE_MODE_WRITE)
+	tmp := name + tmpSuffix
+
+	// delete any stale temporary file to discard its content, Delete also
+	// closes the file handle
+	if file, err := open(volume, tmp, mode); err == nil {
+		if err = call(file, fileDelete, file); err != nil {
+			return fmt.Errorf("could not delete temporary file, %v", err)
+		}
+	}
+
+	file, err := open(volume, tmp, mode|uefi.EFI_FILE_MODE_CREATE)
+
+	if err != nil {
+		return fmt.Errorf("could not create file, %v", err)
+	}
+
+	if err = write(file, data); err != nil {
+		call(file, fileDelete, file)
+		return fmt.Errorf("could not write file, %v", err)
+	}
+
+	defer call(file, fileClose, file)
+
+	// EFI_FILE_PROTOCOL.SetInfo() does not allow renaming over existing
+	// files, the previous content is removed only now that the new one is
+	// complete.
+	if old, err := open(volume, name, mode); err == nil {
+		if err = call(old, fileDelete, old); err != nil {
+			return fmt.Errorf("could not delete file, %v", err)
+		}
+	}
+
+	if err = rename(file, name); err != nil {
+		return fmt.Errorf("could not rename file, %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,307 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package guestmsg implements VM Communication Key (VMPCK) selection and
+// message sequencing for AMD SEV-SNP guest requests.
+//
+// Sealed messages are exchanged with the firmware through a [Transport], such
+// as the kvm package GHCB guest request implementation.
+package guestmsg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NumVMPCK is the number of VM Communication Keys.
+const NumVMPCK = 4
+
+// maximum number of transmissions for a guest message not acknowledged by the
+// hypervisor
+const retries = 8
+
+var (
+	// ErrNoVMPCK is returned when all VMPCKs have been invalidated.
+	ErrNoVMPCK = errors.New("no VMPCK available")
+
+	// ErrFirmware represents a guest request failure after the firmware
+	// processed the request, the VMPCK sequence state is then unknown.
+	ErrFirmware = errors.New("invalid firmware response")
+
+	// ErrCertsLength represents an SNP Extended Guest Request with an
+	// insufficient certificate buffer, the request is still processed.
+	ErrCertsLength = errors.New("certificate buffer too small")
+)
+
+// Transport represents the guest message transport.
+type Transport interface {
+	// Seal encrypts a guest request with the argument key, and its
+	// current sequence number, returning the sealed message.
+	Seal(k *Key, messageType int, req []byte) (msg []byte, err error)
+
+	// Send transmits a sealed message, as an SNP Extended Guest Request
+	// when ext is true, and returns its decrypted response. The key
+	// sequence number must be advanced for each message consumed by the
+	// firmware, any error not wrapping [ErrFirmware] or [ErrCertsLength]
+	// is treated as a message not acknowledged by the hypervisor.
+	Send(k *Key, msg []byte, ext bool) (res []byte, certs []byte, err error)
+}
+
+// State represents the state of a VM Communication Key.
+type State struct {
+	// Index is the VMPCK index (0-3).
+	Index int
+	// Seq is the next message sequence number.
+	Seq uint64
+	// Requests is the number of successful guest requests.
+	Requests uint64
+	// Pending reports whether a request, not acknowledged by the
+	// hypervisor, must be retransmitted before any other.
+	Pending bool
+	// Disabled reports whether the key has been invalidated.
+	Disabled bool
+	// Err is the error which caused invalidation.
+	Err error
+	// Selected reports whether the key is used for guest requests.
+	Selected bool
+}
+
+// Key represents a VM Communication Key and its message sequence.
+type Key struct {
+	State
+
+	// Secret is the key value.
+	Secret []byte
+
+	// sealed request (and its type) not acknowledged by the hypervisor
+	pending    []byte
+	pendingExt bool
+}
+
+// Keys represents the guest message manager, which owns VMPCK selection and
+// sequence numbers. Its methods are not safe for concurrent use.
+type Keys struct {
+	keys      []*Key
+	preferred int
+
+	// Wipe is, when set, invoked on key invalidation to wipe any other
+	// copy of the key.
+	Wipe func(index int)
+}
+
+// New returns the guest message manager for the argument VMPCK values, all
+// zero keys are treated as not available.
+func New(keys [][]byte, preferred int) (m *Keys, err error) {
+	if len(keys) > NumVMPCK {
+		return nil, errors.New("invalid number of keys")
+	}
+
+	if preferred < 0 || preferred >= len(keys) {
+		return nil, fmt.Errorf("invalid VMPCK index %d", preferred)
+	}
+
+	m = &Keys{
+		preferred: preferred,
+	}
+
+	for i, secret := range keys {
+		k := &Key{
+			State: State{
+				Index: i,
+				Seq:   1,
+			},
+			Secret: secret,
+		}
+
+		if isZero(secret) {
+			k.Disabled = true
+			k.Err = errors.New("key not available")
+		}
+
+		m.keys = append(m.keys, k)
+	}
+
+	return
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Disable invalidates a VMPCK, as its sequence state is unknown it must never
+// be used again to prevent AES-GCM nonce reuse.
+func (m *Keys) Disable(k *Key, err error) {
+	k.Disabled = true
+	k.Err = err
+	k.Pending = false
+	k.pending = nil
+
+	clear(k.Secret)
+
+	if m.Wipe != nil {
+		m.Wipe(k.Index)
+	}
+}
+
+// DisableAll invalidates all VMPCKs, guest requests are no longer possible
+// afterwards.
+func (m *Keys) DisableAll(err error) {
+	for _, k := range m.keys {
+		if !k.Disabled {
+			m.Disable(k, err)
+		}
+	}
+}
+
+// State returns the state of all VMPCKs.
+func (m *Keys) State() (state []State) {
+	selected := m.Current()
+
+	for _, k := range m.keys {
+		k.Selected = k == selected
+		state = append(state, k.State)
+	}
+
+	return
+}
+
+// SetPreferred sets the preferred VMPCK for guest requests, other keys are
+// only used as fallback on invalidation.
+func (m *Keys) SetPreferred(index int) (err error) {
+	if index < 0 || index >= len(m.keys) {
+		return fmt.Errorf("invalid VMPCK index %d", index)
+	}
+
+	if k := m.keys[index]; k.Disabled {
+		return fmt.Errorf("VMPCK%d disabled, %v", index, k.Err)
+	}
+
+	m.preferred = index
+
+	return
+}
+
+// Current returns the preferred VMPCK, or the first enabled one.
+func (m *Keys) Current() *Key {
+	if k := m.keys[m.preferred]; !k.Disabled {
+		return k
+	}
+
+	for _, k := range m.keys {
+		if !k.Disabled {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// Request issues a guest request, as an SNP Extended Guest Request returning
+// the hypervisor certificate table when ext is true, through the argument
+// transport.
+//
+// Requests are encrypted with the current VMPCK, falling back to the next
+// available one if invalidated by a firmware response failure.
+func (m *Keys) Request(t Transport, messageType int, req []byte, ext bool) (res []byte, certs []byte, err error) {
+	var msg []byte
+
+	for {
+		k := m.Current()
+
+		if k == nil {
+			return nil, nil, ErrNoVMPCK
+		}
+
+		// A request which was not acknowledged by the hypervisor must
+		// be retransmitted unchanged, as its sequence number cannot be
+		// reused for a different message.
+		if k.pending != nil {
+			switch err = m.flush(t, k); {
+			case errors.Is(err, ErrFirmware):
+				m.Disable(k, err)
+				continue
+			case err != nil:
+				return
+			}
+		}
+
+		if msg, err = t.Seal(k, messageType, req); err != nil {
+			return
+		}
+
+		res, certs, err = retransmit(t, k, msg, ext)
+
+		switch {
+		case errors.Is(err, ErrFirmware):
+			m.Disable(k, err)
+			continue
+		case errors.Is(err, ErrCertsLength):
+			// request processed, without response
+			return
+		case err != nil:
+			k.pending = msg
+			k.pendingExt = ext
+			k.Pending = true
+			return
+		}
+
+		k.Requests += 1
+
+		return
+	}
+}
+
+// flush retransmits the pending request of the argument key, its response is
+// discarded.
+func (m *Keys) flush(t Transport, k *Key) (err error) {
+	_, _, err = retransmit(t, k, k.pending, k.pendingExt)
+
+	// The hypervisor might keep rejecting extended requests, the same
+	// sealed message is then sent as a plain guest request (as it is done
+	// for an insufficient certificate buffer) so that the key is not
+	// stuck on it.
+	if k.pendingExt && unacknowledged(err) {
+		k.pendingExt = false
+		_, _, err = retransmit(t, k, k.pending, false)
+	}
+
+	if unacknowledged(err) {
+		return
+	}
+
+	k.pending = nil
+	k.Pending = false
+
+	if errors.Is(err, ErrCertsLength) {
+		err = nil
+	}
+
+	return
+}
+
+// unacknowledged returns whether the argument error represents a message not
+// acknowledged by the hypervisor.
+func unacknowledged(err error) bool {
+	return err != nil && !errors.Is(err, ErrFirmware) && !errors.Is(err, ErrCertsLength)
+}
+
+// retransmit sends a sealed guest message, the identical message is sent
+// again (which is safe as the nonce and plaintext are unchanged) whenever the
+// hypervisor fails to acknowledge it.
+func retransmit(t Transport, k *Key, msg []byte, ext bool) (res []byte, certs []byte, err error) {
+	for range retries {
+		if res, certs, err = t.Send(k, msg, ext); !unacknowledged(err) {
+			break
+		}
+	}
+
+	return
+}
@@ -0,0 +1,327 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package guestmsg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+var errExit = errors.New("exit error")
+
+// firmware represents a fake transport, sealed messages are the key index and
+// sequence number followed by the plaintext request.
+type firmware struct {
+	// next expected sequence number for each key
+	seq map[int]uint64
+	// sent messages
+	sent []sent
+
+	// exit returns, when set, the hypervisor exit error for a message
+	exit func(msg []byte, ext bool) error
+	// fwErr returns, when set, the firmware error for a message
+	fwErr func(msg []byte) error
+	// certsLength reports an insufficient certificate buffer on extended
+	// requests
+	certsLength bool
+}
+
+type sent struct {
+	msg []byte
+	ext bool
+}
+
+func newFirmware() *firmware {
+	return &firmware{
+		seq: make(map[int]uint64),
+	}
+}
+
+func (f *firmware) Seal(k *Key, messageType int, req []byte) (msg []byte, err error) {
+	msg = binary.LittleEndian.AppendUint64([]byte{byte(k.Index)}, k.Seq)
+	msg = append(msg, req...)
+
+	return
+}
+
+func (f *firmware) Send(k *Key, msg []byte, ext bool) (res []byte, certs []byte, err error) {
+	f.sent = append(f.sent, sent{bytes.Clone(msg), ext})
+
+	if f.exit != nil {
+		if err = f.exit(msg, ext); err != nil {
+			return
+		}
+	}
+
+	if seq, ok := f.seq[k.Index]; ok && seq != k.Seq {
+		return nil, nil, fmt.Errorf("%w, sequence reused", ErrFirmware)
+	}
+
+	if seq := binary.LittleEndian.Uint64(msg[1:9]); seq != k.Seq {
+		return nil, nil, fmt.Errorf("%w, message sequence mismatch", ErrFirmware)
+	}
+
+	k.Seq += 1
+	f.seq[k.Index] = k.Seq + 1
+
+	if f.fwErr != nil {
+		if err = f.fwErr(msg); err != nil {
+			return
+		}
+	}
+
+	k.Seq += 1
+
+	if ext && f.certsLength {
+		return nil, nil, ErrCertsLength
+	}
+
+	if ext {
+		certs = []byte("certs")
+	}
+
+	return msg[9:], certs, nil
+}
+
+func testKeys(t *testing.T, n int) *Keys {
+	var keys [][]byte
+
+	for i := range n {
+		keys = append(keys, bytes.Repeat([]byte{byte(i + 1)}, 32))
+	}
+
+	m, err := New(keys, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestNew(t *testing.T) {
+	m, err := New([][]byte{make([]byte, 32), {1}}, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k := m.Current(); k == nil || k.Index != 1 {
+		t.Errorf("got %+v, want VMPCK1", k)
+	}
+
+	if err = m.SetPreferred(0); err == nil {
+		t.Error("expected error on unavailable key")
+	}
+
+	if _, err = New(nil, 0); err == nil {
+		t.Error("expected error on invalid preferred key")
+	}
+
+	if _, err = New(make([][]byte, NumVMPCK+1), 0); err == nil {
+		t.Error("expected error on invalid number of keys")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	m := testKeys(t, 1)
+	f := newFirmware()
+
+	for i, ext := range []bool{false, true, false} {
+		res, certs, err := m.Request(f, 1, []byte("request"), ext)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(res, []byte("request")) {
+			t.Errorf("got %q", res)
+		}
+
+		if (certs != nil) != ext {
+			t.Errorf("got certs %q, want ext %v", certs, ext)
+		}
+
+		if s := m.State()[0]; s.Requests != uint64(i+1) || s.Seq != uint64(2*i+3) {
+			t.Errorf("got %+v", s)
+		}
+	}
+}
+
+func TestRequestRetransmit(t *testing.T) {
+	m := testKeys(t, 1)
+	f := newFirmware()
+	drops := 2
+
+	f.exit = func([]byte, bool) error {
+		if drops > 0 {
+			drops--
+			return errExit
+		}
+
+		return nil
+	}
+
+	if _, _, err := m.Request(f, 1, []byte("request"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.sent) != 3 {
+		t.Errorf("got %d transmissions, want 3", len(f.sent))
+	}
+
+	for _, s := range f.sent {
+		if !bytes.Equal(s.msg, f.sent[0].msg) {
+			t.Errorf("retransmitted message changed")
+		}
+	}
+}
+
+func TestRequestPending(t *testing.T) {
+	m := testKeys(t, 1)
+	f := newFirmware()
+	down := true
+
+	f.exit = func([]byte, bool) error {
+		if down {
+			return errExit
+		}
+
+		return nil
+	}
+
+	if _, _, err := m.Request(f, 1, []byte("first"), false); !errors.Is(err, errExit) {
+		t.Fatalf("got %v, want exit error", err)
+	}
+
+	if s := m.State()[0]; !s.Pending || s.Requests != 0 || s.Seq != 1 {
+		t.Fatalf("got %+v", s)
+	}
+
+	down = false
+	f.sent = nil
+
+	res, _, err := m.Request(f, 1, []byte("second"), false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(res, []byte("second")) {
+		t.Errorf("got %q", res)
+	}
+
+	// the pending message is retransmitted first, unchanged
+	if len(f.sent) != 2 || !bytes.HasSuffix(f.sent[0].msg, []byte("first")) {
+		t.Errorf("pending message not retransmitted")
+	}
+
+	if s := m.State()[0]; s.Pending || s.Requests != 1 || s.Seq != 5 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestRequestPendingExt(t *testing.T) {
+	m := testKeys(t, 1)
+	f := newFirmware()
+
+	// the hypervisor rejects all extended requests
+	f.exit = func(msg []byte, ext bool) error {
+		if ext {
+			return errExit
+		}
+
+		return nil
+	}
+
+	if _, _, err := m.Request(f, 1, []byte("ext"), true); !errors.Is(err, errExit) {
+		t.Fatalf("got %v, want exit error", err)
+	}
+
+	for i := range 2 {
+		f.sent = nil
+
+		res, _, err := m.Request(f, 1, []byte("plain"), false)
+
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+
+		if !bytes.Equal(res, []byte("plain")) {
+			t.Errorf("request %d: got %q", i, res)
+		}
+	}
+
+	// the pending extended request is resent as a plain one, only once
+	if s := m.State()[0]; s.Pending || s.Disabled || s.Requests != 2 || s.Seq != 7 {
+		t.Errorf("got %+v", s)
+	}
+
+	if len(f.sent) != 1 {
+		t.Errorf("got %d transmissions, want 1", len(f.sent))
+	}
+}
+
+func TestRequestCertsLength(t *testing.T) {
+	m := testKeys(t, 1)
+	f := newFirmware()
+
+	f.certsLength = true
+
+	res, _, err := m.Request(f, 1, []byte("request"), true)
+
+	if !errors.Is(err, ErrCertsLength) || res != nil {
+		t.Fatalf("got %q, %v, want certificate length error", res, err)
+	}
+
+	// the request is processed, but not counted as no response is returned
+	if s := m.State()[0]; s.Pending || s.Requests != 0 || s.Seq != 3 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestRequestFirmwareError(t *testing.T) {
+	var wiped []int
+
+	m := testKeys(t, 2)
+	m.Wipe = func(index int) { wiped = append(wiped, index) }
+	f := newFirmware()
+
+	f.fwErr = func(msg []byte) error {
+		if msg[0] == 0 {
+			return fmt.Errorf("%w, firmware error", ErrFirmware)
+		}
+
+		return nil
+	}
+
+	if _, _, err := m.Request(f, 1, []byte("request"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	state := m.State()
+
+	if !state[0].Disabled || !state[1].Selected || state[1].Requests != 1 {
+		t.Errorf("got %+v", state)
+	}
+
+	if len(wiped) != 1 || wiped[0] != 0 {
+		t.Errorf("got wiped keys %v, want [0]", wiped)
+	}
+
+	if !bytes.Equal(m.keys[0].Secret, make([]byte, 32)) {
+		t.Error("key not cleared")
+	}
+
+	m.DisableAll(errors.New("teardown"))
+
+	if _, _, err := m.Request(f, 1, []byte("request"), false); !errors.Is(err, ErrNoVMPCK) {
+		t.Errorf("got %v, want ErrNoVMPCK", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package https
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+)
+
+// minimum interval between attestation requests, to protect the guest
+// request path from remote abuse
+const evidenceInterval = 1 * time.Second
+
+var evidence struct {
+	sync.Mutex
+	last time.Time
+}
+
+func init() {
+	http.HandleFunc(attest.EvidencePath, evidenceHandler)
+}
+
+// evidenceHandler serves attestation evidence, the nonce query parameter (hex
+// encoded, 1 to 64 bytes) is embedded as REPORT_DATA.
+func evidenceHandler(w http.ResponseWriter, r *http.Request) {
+	var report *sev.AttestationReport
+	var certs []byte
+
+	nonce, err := hex.DecodeString(r.FormValue("nonce"))
+
+	if err != nil || len(nonce) == 0 || len(nonce) > attest.ReportDataSize {
+		http.Error(w, "invalid nonce", http.StatusBadRequest)
+		return
+	}
+
+	if !evidence.TryLock() {
+		http.Error(w, "busy", http.StatusTooManyRequests)
+		return
+	}
+
+	defer evidence.Unlock()
+
+	if since := time.Since(evidence.last); since >= 0 && since < evidenceInterval {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", evidenceInterval.Seconds()))
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+
+	evidence.last = time.Now()
+
+	if report, certs, err = kvm.ExtendedReport(nonce); err != nil {
+		report, err = kvm.Report(nonce)
+	}
+
+	if err != nil {
+		log.Printf("could not get report, %v", err)
+		http.Error(w, "attestation unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if _, err = attest.ParseCertTable(certs); err != nil {
+		certs = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(&attest.Evidence{
+		Nonce:        nonce,
+		Report:       report.Bytes(),
+		Certificates: certs,
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package https
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+)
+
+const addr = ":443"
+
+// certificate returns a self-signed TLS certificate for the VM unique key, the
+// certificate carries a fresh attestation report bound to its public key.
+func certificate() (cert tls.Certificate, err error) {
+	key, err := kvm.TLSKey()
+
+	if err != nil {
+		return
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	if err != nil {
+		return cert, fmt.Errorf("could not marshal public key, %v", err)
+	}
+
+	report, err := kvm.Report(attest.TLSReportData(spki))
+
+	if err != nil {
+		return cert, fmt.Errorf("could not get report, %v", err)
+	}
+
+	ext, err := asn1.Marshal(report.Bytes())
+
+	if err != nil {
+		return cert, fmt.Errorf("could not marshal report, %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "tamago-sev-example",
+		},
+		// the unikernel clock is not trusted, validity is conveyed by
+		// the attestation report
+		NotBefore:   time.Unix(0, 0),
+		NotAfter:    time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:    attest.ReportExtensionOID,
+				Value: ext,
+			},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return cert, fmt.Errorf("could not create certificate, %v", err)
+	}
+
+	cert.Certificate = [][]byte{der}
+	cert.PrivateKey = key
+
+	return
+}
+
+// Start serves [http.DefaultServeMux] over TLS, authenticated with a
+// certificate carrying an attestation report.
+func Start() {
+	cert, err := certificate()
+
+	if err != nil {
+		log.Printf("could not create attested certificate, %v", err)
+		return
+	}
+
+	srv := &http.Server{
+		Addr: addr,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+
+	err = srv.ListenAndServeTLS("", "")
+
+	log.Printf("https server terminated, %v", err)
+}
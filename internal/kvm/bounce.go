@@ -0,0 +1,56 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"sync"
+
+	"github.com/usbarmory/tamago-sev-example/internal/bounce"
+)
+
+// BounceSize is the size of the shared memory pool used for bounce buffers.
+const BounceSize = 4 << 20
+
+var bouncePool struct {
+	sync.Mutex
+	pool *bounce.Pool
+	// backing memory
+	buf []byte
+}
+
+// Bounce returns the bounce buffer pool, its shared memory region is allocated
+// on first use and is distinct from the default DMA region.
+func Bounce() (p *bounce.Pool, err error) {
+	bouncePool.Lock()
+	defer bouncePool.Unlock()
+
+	if bouncePool.pool != nil {
+		return bouncePool.pool, nil
+	}
+
+	r, buf, err := allocShared(BounceSize)
+
+	if err != nil {
+		return
+	}
+
+	bouncePool.pool = bounce.NewPool(r)
+	bouncePool.buf = buf
+
+	return bouncePool.pool, nil
+}
+
+// BounceStats returns the bounce buffer pool statistics, if allocated.
+func BounceStats() (names []string, stats []bounce.Stats) {
+	bouncePool.Lock()
+	defer bouncePool.Unlock()
+
+	if bouncePool.pool == nil {
+		return
+	}
+
+	return bouncePool.pool.Stats()
+}
@@ -0,0 +1,179 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/usbarmory/tamago/dma"
+	"github.com/usbarmory/tamago/kvm/sev"
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 14: CPUID Page Format.
+const (
+	cpuidCount      = 0x00
+	cpuidEntries    = 0x10
+	cpuidEntrySize  = 48
+	maxCPUIDEntries = 64
+
+	// entry fields
+	cpuidEAXIn = 0x00
+	cpuidECXIn = 0x04
+	cpuidEAX   = 0x18
+	cpuidEBX   = 0x1c
+	cpuidECX   = 0x20
+	cpuidEDX   = 0x24
+)
+
+// cpuidAddr is the CPUID Page physical address.
+var cpuidAddr uint64
+
+// CPUIDEntry represents a CPUID Page entry, holding CPUID function values
+// validated by the AMD SEV-SNP firmware at launch.
+type CPUIDEntry struct {
+	Leaf    uint32
+	Subleaf uint32
+	XCR0    uint64
+	XSS     uint64
+	EAX     uint32
+	EBX     uint32
+	ECX     uint32
+	EDX     uint32
+	_       uint64
+}
+
+// CPUIDPage returns all CPUID Page entries.
+func CPUIDPage() (entries []CPUIDEntry, err error) {
+	if cpuidAddr == 0 {
+		return nil, errors.New("CPUID page not available")
+	}
+
+	size := cpuidEntries + maxCPUIDEntries*cpuidEntrySize
+	r, err := dma.NewRegion(uint(cpuidAddr), size, false)
+
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, size)
+	r.Read(uint(cpuidAddr), 0, buf)
+
+	count := binary.LittleEndian.Uint32(buf[cpuidCount:])
+
+	if count > maxCPUIDEntries {
+		return nil, fmt.Errorf("invalid CPUID page entry count (%d)", count)
+	}
+
+	entries = make([]CPUIDEntry, count)
+	_, err = binary.Decode(buf[cpuidEntries:], binary.LittleEndian, entries)
+
+	return
+}
+
+// TrustedCPUID returns the CPUID function values validated by the AMD SEV-SNP
+// firmware at launch.
+func TrustedCPUID(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32, err error) {
+	var found bool
+
+	if cpuidAddr == 0 {
+		return 0, 0, 0, 0, errors.New("CPUID page not available")
+	}
+
+	if eax, ebx, ecx, edx, found = cpuidLookup(leaf, subleaf); !found {
+		return 0, 0, 0, 0, fmt.Errorf("function %#x/%#x not found in CPUID page", leaf, subleaf)
+	}
+
+	return
+}
+
+// HypervisorCPUID returns the CPUID function values reported by the
+// hypervisor, through a GHCB CPUID exit, for the executing vCPU.
+func HypervisorCPUID(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32, err error) {
+	xcr0 := uint64(1)
+
+	if cr4()&cr4OSXSAVE != 0 {
+		xcr0 = xgetbv()
+	}
+
+	err = WithGHCB(func(b *sev.GHCB) (err error) {
+		info1, _, err := ghcbExit(b, exitCPUID, map[uint]uint64{
+			sev.RAX:  uint64(leaf),
+			ghcbRCX:  uint64(subleaf),
+			ghcbXCR0: xcr0,
+		})
+
+		if err != nil {
+			return
+		}
+
+		if uint32(info1) != 0 {
+			return fmt.Errorf("exit error (info1:%#x)", info1)
+		}
+
+		eax = uint32(ghcbRead(b, sev.RAX))
+		ebx = uint32(ghcbRead(b, RBX))
+		ecx = uint32(ghcbRead(b, ghcbRCX))
+		edx = uint32(ghcbRead(b, ghcbRDX))
+
+		return
+	})
+
+	return
+}
+
+// cpuidIndexed reports whether the argument CPUID function is indexed by
+// sub-function (ECX).
+//
+//go:nosplit
+func cpuidIndexed(leaf uint32) bool {
+	switch leaf {
+	case 0x4, 0x7, 0xb, 0xd, 0xf, 0x10, 0x12, 0x14, 0x17, 0x18, 0x1d, 0x1e, 0x1f, 0x8000001d:
+		return true
+	}
+
+	return false
+}
+
+// cpuidLookup returns the CPUID function values validated by the AMD SEV-SNP
+// firmware at launch, as found in the CPUID Page.
+//
+//go:nosplit
+func cpuidLookup(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32, found bool) {
+	if cpuidAddr == 0 {
+		return
+	}
+
+	count := uint32(read64(cpuidAddr + cpuidCount))
+
+	if count > maxCPUIDEntries {
+		return
+	}
+
+	for i := uint64(0); i < uint64(count); i++ {
+		e := cpuidAddr + cpuidEntries + i*cpuidEntrySize
+		in := read64(e + cpuidEAXIn)
+
+		if uint32(in) != leaf {
+			continue
+		}
+
+		if cpuidIndexed(leaf) && uint32(in>>32) != subleaf {
+			continue
+		}
+
+		eax = uint32(read64(e + cpuidEAX))
+		ebx = uint32(read64(e + cpuidEBX))
+		ecx = uint32(read64(e + cpuidECX))
+		edx = uint32(read64(e + cpuidEDX))
+
+		return eax, ebx, ecx, edx, true
+	}
+
+	return
+}
@@ -0,0 +1,79 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+)
+
+// maximum number of queued guest requests
+const queueSize = 16
+
+// guestMessage represents a queued guest request.
+type guestMessage struct {
+	messageType int
+	req         []byte
+	ext         bool
+
+	done chan guestResponse
+}
+
+// guestResponse represents a guest request result.
+type guestResponse struct {
+	res   []byte
+	certs []byte
+	err   error
+}
+
+// dispatcher serializes all guest requests through a single goroutine, pinned
+// to a vCPU to ensure that its GHCB is consistently used.
+var dispatcher struct {
+	sync.Once
+	queue chan *guestMessage
+}
+
+func dispatch() {
+	// pin goroutine to the current vCPU
+	runtime.LockOSThread()
+
+	for m := range dispatcher.queue {
+		r := guestResponse{}
+
+		r.err = WithGHCB(func(b *sev.GHCB) (err error) {
+			r.res, r.certs, err = guestRequest(b, m.messageType, m.req, m.ext)
+			return
+		})
+
+		m.done <- r
+	}
+}
+
+// request queues a guest request for dispatching and waits for its result.
+func request(messageType int, req []byte, ext bool) (res []byte, certs []byte, err error) {
+	if GHCB == nil || Secrets == nil {
+		return nil, nil, ErrNotPresent
+	}
+
+	dispatcher.Do(func() {
+		dispatcher.queue = make(chan *guestMessage, queueSize)
+		go dispatch()
+	})
+
+	m := &guestMessage{
+		messageType: messageType,
+		req:         req,
+		ext:         ext,
+		done:        make(chan guestResponse, 1),
+	}
+
+	dispatcher.queue <- m
+	r := <-m.done
+
+	return r.res, r.certs, r.err
+}
@@ -0,0 +1,155 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/goos"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/go-boot/uefi/x64"
+)
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 2.6 GHCB Layout.
+const RBX = 0x0318
+
+const pageSize = 4096
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 2.3.2 GHCB MSR Protocol, GHCB GPA Registration.
+const (
+	msrInfoMask            = 0xfff
+	msrRegisterGPARequest  = 0x012
+	msrRegisterGPAResponse = 0x013
+)
+
+// defined in ghcb.s
+func vmgexit()
+func wrmsr(addr uint64, val uint64)
+
+// registerGPA registers, through the GHCB MSR protocol, the argument GHCB GPA
+// for the executing vCPU and sets it as current.
+//
+//go:nosplit
+func registerGPA(gpa uint64) (val uint64, ok bool) {
+	gfn := gpa >> 12
+
+	wrmsr(sev.MSR_AMD_GHCB, gfn<<12|msrRegisterGPARequest)
+	vmgexit()
+
+	if val = rdmsr(sev.MSR_AMD_GHCB); val&msrInfoMask != msrRegisterGPAResponse || val>>12 != gfn {
+		return
+	}
+
+	wrmsr(sev.MSR_AMD_GHCB, gpa)
+
+	return val, true
+}
+
+func registerGHCB(gpa uint64) (err error) {
+	if val, ok := registerGPA(gpa); !ok {
+		return fmt.Errorf("could not register GHCB GPA %#x (%#x)", gpa, val)
+	}
+
+	return
+}
+
+// WithGHCB invokes the argument function with the GHCB instance of the
+// executing vCPU, pinning the calling goroutine to it.
+//
+// The unikernel GHCB GPA is registered for the duration of the call, while
+// EFI boot services are available the previous (OVMF) GHCB GPA is then
+// registered again as required by its #VC handler.
+func WithGHCB(fn func(b *sev.GHCB) error) (err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	n := goos.ProcID()
+	b, ok := GHCB[n]
+
+	if !ok {
+		return fmt.Errorf("no GHCB for vCPU%d", n)
+	}
+
+	gpa := layouts[n]
+	prev := x64.AMD64.MSR(sev.MSR_AMD_GHCB)
+
+	if gpa == 0 {
+		return errors.New("GHCB layout not allocated")
+	}
+
+	if prev != gpa {
+		if err = registerGHCB(gpa); err != nil {
+			return
+		}
+
+		defer func() {
+			if prev == 0 || x64.Console.Out == 0 {
+				return
+			}
+
+			if e := registerGHCB(prev); e != nil && err == nil {
+				err = e
+			}
+		}()
+	}
+
+	return fn(b)
+}
+
+// ghcbExit triggers a VMGEXIT after setting, and marking as valid, the
+// argument GHCB fields (offset to value map). Unlike [sev.GHCB.Exit] this
+// allows issuing Non-Automatic Events which require fields other than RAX.
+//
+// The exit information is returned without any interpretation.
+func ghcbExit(b *sev.GHCB, code uint64, fields map[uint]uint64) (info1 uint64, info2 uint64, err error) {
+	if b.Layout == nil {
+		// trigger opportunistic Layout initialization
+		if _, err = b.HypervisorFeatures(); err != nil {
+			return
+		}
+	}
+
+	addr := b.Layout.Start()
+	valid := make([]byte, 16)
+	val := make([]byte, 8)
+
+	fields[sev.SW_EXITCODE] = code
+
+	for off, v := range fields {
+		binary.LittleEndian.PutUint64(val, v)
+		b.Layout.Write(addr, int(off), val)
+
+		bit := off / 8
+		valid[bit/8] |= 1 << (bit % 8)
+	}
+
+	b.Layout.Write(addr, sev.VALID_BITMAP, valid)
+
+	vmgexit()
+
+	if exit := ghcbRead(b, sev.SW_EXITCODE); exit != code {
+		return 0, 0, fmt.Errorf("exit code mismatch (%#x)", exit)
+	}
+
+	info1 = ghcbRead(b, sev.SW_EXITINFO1)
+	info2 = ghcbRead(b, sev.SW_EXITINFO2)
+
+	return
+}
+
+// ghcbRead reads a GHCB field at the argument offset.
+func ghcbRead(b *sev.GHCB, off uint) uint64 {
+	val := make([]byte, 8)
+	b.Layout.Read(b.Layout.Start(), int(off), val)
+
+	return binary.LittleEndian.Uint64(val)
+}
@@ -0,0 +1,24 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func vmgexit()
+TEXT ·vmgexit(SB),NOSPLIT,$0
+	// vmgexit
+	BYTE	$0xf3
+	BYTE	$0x0f
+	BYTE	$0x01
+	BYTE	$0xd9
+	RET
+
+// func wrmsr(addr uint64, val uint64)
+TEXT ·wrmsr(SB),NOSPLIT,$0-16
+	MOVQ	addr+0(FP), CX
+	MOVQ	val+8(FP), AX
+	MOVQ	AX, DX
+	SHRQ	$32, DX
+	WRMSR
+	RET
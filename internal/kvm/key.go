@@ -6,13 +6,14 @@
 package kvm
 
 import (
+	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/hkdf"
 	"crypto/sha256"
-	"crypto/x509"
-	"encoding/pem"
+	"encoding/binary"
 	"fmt"
-	"runtime/goos"
+	"strings"
+	"sync"
 
 	"filippo.io/keygen"
 	"golang.org/x/crypto/ssh"
@@ -20,65 +21,254 @@ import (
 	"github.com/usbarmory/tamago/kvm/sev"
 )
 
-const retries = 8
+// KeyRoot represents the root key (and endorsement key) selection for key
+// derivation.
+type KeyRoot uint32
 
-func deriveGuestKey() (key []byte, err error) {
-	if GHCB == nil {
-		return nil, fmt.Errorf("GHCB not present")
+// Key derivation root keys
+const (
+	// Versioned Chip Endorsement Key, or VLEK if installed
+	KeyRootDefault KeyRoot = sev.RootKeySelVCEK | sev.KeySelVLEKOrVCEK
+	// Versioned Chip Endorsement Key
+	KeyRootVCEK KeyRoot = sev.RootKeySelVCEK | sev.KeySelVCEK
+	// Versioned Loaded Endorsement Key
+	KeyRootVLEK KeyRoot = sev.RootKeySelVCEK | sev.KeySelVLEK
+	// VM Root Key
+	KeyRootVMRK KeyRoot = sev.RootKeySelVMRK
+)
+
+// KeyField represents the guest fields mixed into key derivation.
+type KeyField uint64
+
+// Key derivation guest fields
+const (
+	KeyFieldPolicy          KeyField = sev.GuestPolicy
+	KeyFieldImageID         KeyField = sev.ImageID
+	KeyFieldFamilyID        KeyField = sev.FamilyID
+	KeyFieldMeasurement     KeyField = sev.Measurement
+	KeyFieldGuestSVN        KeyField = sev.GuestSVN
+	KeyFieldTCBVersion      KeyField = sev.TCBVersion
+	KeyFieldLaunchMitVector KeyField = sev.LaunchMitVector
+)
+
+var keyFieldNames = []struct {
+	field KeyField
+	name  string
+}{
+	{KeyFieldPolicy, "policy"},
+	{KeyFieldImageID, "image"},
+	{KeyFieldFamilyID, "family"},
+	{KeyFieldMeasurement, "measurement"},
+	{KeyFieldGuestSVN, "svn"},
+	{KeyFieldTCBVersion, "tcb"},
+	{KeyFieldLaunchMitVector, "mit"},
+}
+
+// ParseKeyFields parses a comma separated list of guest field names (policy,
+// image, family, measurement, svn, tcb, mit).
+func ParseKeyFields(s string) (fields KeyField, err error) {
+	for _, name := range strings.Split(s, ",") {
+		var field KeyField
+
+		for _, n := range keyFieldNames {
+			if n.name == name {
+				field = n.field
+			}
+		}
+
+		if field == 0 {
+			return 0, fmt.Errorf("invalid guest field %q", name)
+		}
+
+		fields |= field
+	}
+
+	return
+}
+
+// String returns the comma separated list of selected guest field names.
+func (f KeyField) String() string {
+	var names []string
+
+	for _, n := range keyFieldNames {
+		if f&n.field != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// KeyPolicy represents a key derivation policy, the derived key is bound to
+// the selected root key and guest fields.
+//
+// Keys which must survive measured updates should not select the
+// measurement, but rather bind to the FamilyID (and optionally a minimum
+// GuestSVN) set in the ID block at launch.
+type KeyPolicy struct {
+	// Root is the root key selection.
+	Root KeyRoot
+	// Fields is the selection of guest fields mixed into the derived key.
+	Fields KeyField
+
+	// VMPL is the VM Privilege Level mixed into the derived key, it is
+	// raised to the current VMPL when lower.
+	VMPL uint32
+	// GuestSVN is mixed into the derived key when selected, it must not
+	// exceed the guest SVN set at launch.
+	GuestSVN uint32
+	// TCBVersion is mixed into the derived key when selected, it must not
+	// exceed the current TCB version.
+	TCBVersion uint64
+	// LaunchMitVector is mixed into the derived key when selected.
+	LaunchMitVector uint64
+
+	// Mix is an application defined value mixed, as HKDF salt, into keys
+	// derived with [DeriveKey].
+	Mix uint64
+}
+
+// DefaultKeyPolicy represents the default key derivation policy, bound to
+// the platform endorsement key (VLEK if installed, VCEK otherwise), Guest
+// Policy and VM identity.
+var DefaultKeyPolicy = &KeyPolicy{
+	Root:   KeyRootDefault,
+	Fields: KeyFieldGuestSVN | KeyFieldMeasurement | KeyFieldPolicy,
+}
+
+// IdentityKeyPolicy represents the key derivation policy for the VM unique
+// keys returned by [Signer] and [TLSKey].
+var IdentityKeyPolicy = DefaultKeyPolicy
+
+// DeriveGuestKey requests a 32-byte key derived by the AMD SEV-SNP firmware
+// according to the argument policy.
+func DeriveGuestKey(policy *KeyPolicy) (key []byte, err error) {
+	var buf []byte
+
+	if policy == nil {
+		policy = DefaultKeyPolicy
 	}
 
-	// Perform key derivation from the physical CPU keys, bound to the
-	// Guest Policy and VM identity.
 	req := &sev.KeyRequest{
-		KeySelect:        sev.RootKeySelVCEK,
-		GuestFieldSelect: sev.GuestSVN | sev.Measurement | sev.GuestPolicy,
+		KeySelect:        uint32(policy.Root),
+		GuestFieldSelect: uint64(policy.Fields),
+		VMPL:             max(policy.VMPL, uint32(VMPL())),
+		GuestSVN:         policy.GuestSVN,
+		TCBVersion:       policy.TCBVersion,
+		LaunchMitVector:  policy.LaunchMitVector,
 	}
 
-	vmpck := Secrets.VMPCK0[:]
+	if buf, _, err = request(sev.MSG_KEY_REQ, req.Bytes(), false); err != nil {
+		return
+	}
 
-	// retry a few times as this might faile due to vCPU switch
-	for _ = range retries {
-		ghcb := GHCB[goos.ProcID()]
+	res := &sev.KeyResponse{}
 
-		if key, err = ghcb.DeriveKey(req, vmpck, 0); err != nil {
-			continue
-		}
+	if _, err = binary.Decode(buf, binary.LittleEndian, res); err != nil {
+		return nil, fmt.Errorf("could not parse response, %v", err)
+	}
 
-		break
+	if res.Status != 0 {
+		return nil, fmt.Errorf("request error, %#x", res.Status)
 	}
 
-	return
+	return res.DerivedKey[:], nil
 }
 
-// Signer derives a signer uniquely and deterministically generated for this VM
-// for attestation purposes.
-func Signer() (deviceKey ssh.Signer, err error) {
-	var key []byte
+func deriveKey(policy *KeyPolicy, context string, size int) (key []byte, err error) {
+	var salt []byte
+
+	if policy == nil {
+		policy = DefaultKeyPolicy
+	}
 
-	if key, err = deriveGuestKey(); err != nil {
+	guestKey, err := DeriveGuestKey(policy)
+
+	if err != nil {
 		return nil, fmt.Errorf("could not derive key, %v", err)
 	}
 
-	if key, err = hkdf.Key(sha256.New, key, nil, "ssh-host-key/ecdsa-p256/v1", sha256.BlockSize); err != nil {
+	defer clear(guestKey)
+
+	if policy.Mix != 0 {
+		salt = binary.LittleEndian.AppendUint64(nil, policy.Mix)
+	}
+
+	if key, err = hkdf.Key(sha256.New, guestKey, salt, context, size); err != nil {
 		return nil, fmt.Errorf("could not perform hkdf, %v", err)
 	}
 
-	pk, err := keygen.ECDSA(elliptic.P256(), key)
+	return
+}
 
-	if err != nil {
+// DeriveKey derives a 32-byte key according to the argument policy, the
+// firmware derived key is expanded with HKDF-SHA256 using the argument
+// context as label for domain separation.
+func DeriveKey(policy *KeyPolicy, context string) (key []byte, err error) {
+	return deriveKey(policy, context, sha256.Size)
+}
+
+// derivedKeys tracks the long-lived private keys derived from the guest key
+var derivedKeys struct {
+	sync.Mutex
+	keys []*ecdsa.PrivateKey
+}
+
+// deriveECDSAKey derives a P-256 private key from the guest key, the argument
+// label provides HKDF domain separation. The key is tracked for zeroing on
+// teardown (see [ZeroKeys]).
+func deriveECDSAKey(label string) (pk *ecdsa.PrivateKey, err error) {
+	var key []byte
+
+	if key, err = deriveKey(IdentityKeyPolicy, label, sha256.BlockSize); err != nil {
+		return
+	}
+
+	defer clear(key)
+
+	if pk, err = keygen.ECDSA(elliptic.P256(), key); err != nil {
 		return nil, fmt.Errorf("could not perform keygen, %v", err)
 	}
 
-	der, err := x509.MarshalECPrivateKey(pk)
+	derivedKeys.Lock()
+	derivedKeys.keys = append(derivedKeys.keys, pk)
+	derivedKeys.Unlock()
 
-	if err != nil {
-		return nil, fmt.Errorf("could not marshal key, %v", err)
+	return
+}
+
+// ZeroKeys zeroes the private scalar of all keys returned by [Signer] and
+// [TLSKey], which are no longer usable afterwards. Copies held internally by
+// the Go cryptographic libraries are not reached.
+func ZeroKeys() error {
+	derivedKeys.Lock()
+	defer derivedKeys.Unlock()
+
+	for _, pk := range derivedKeys.keys {
+		clear(pk.D.Bits())
+		pk.D.SetInt64(0)
 	}
 
-	pemBlock := &pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: der,
+	derivedKeys.keys = nil
+
+	return nil
+}
+
+// Signer derives a signer uniquely and deterministically generated for this VM
+// for attestation purposes.
+func Signer() (deviceKey ssh.Signer, err error) {
+	pk, err := deriveECDSAKey("ssh-host-key/ecdsa-p256/v1")
+
+	if err != nil {
+		return
 	}
 
-	return ssh.ParsePrivateKey(pem.EncodeToMemory(pemBlock))
+	// the signer references, rather than copies, the key for ZeroKeys
+	return ssh.NewSignerFromKey(pk)
+}
+
+// TLSKey derives a TLS private key uniquely and deterministically generated
+// for this VM for attestation purposes.
+func TLSKey() (*ecdsa.PrivateKey, error) {
+	return deriveECDSAKey("tls-server-key/ecdsa-p256/v1")
 }
@@ -0,0 +1,159 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/go-boot/uefi"
+	"github.com/usbarmory/go-boot/uefi/x64"
+)
+
+var EFI_ACPI_20_TABLE_GUID = uefi.MustParseGUID("8868e871-e4f1-11d3-bc22-0080c73c8881")
+
+// Advanced Configuration and Power Interface (ACPI) Specification
+// 5.2.5.3 Root System Description Pointer (RSDP) Structure.
+const (
+	rsdpSignature = "RSD PTR "
+	rsdpXSDT      = 24
+	rsdpSize      = 36
+)
+
+// Advanced Configuration and Power Interface (ACPI) Specification
+// 5.2.6 System Description Table Header.
+const (
+	sdtLength     = 4
+	sdtHeaderSize = 36
+)
+
+// Advanced Configuration and Power Interface (ACPI) Specification
+// 5.2.12 Multiple APIC Description Table (MADT).
+const (
+	madtSignature = "APIC"
+	madtEntries   = 44
+
+	madtLocalAPIC   = 0
+	madtLocalX2APIC = 9
+
+	// Local APIC Flags
+	madtEnabled = 1 << 0
+)
+
+// maxTableSize is the maximum ACPI table size accepted for parsing.
+const maxTableSize = 64 * 1024
+
+// readPhys reads the argument physical memory range.
+func readPhys(addr uint64, size int) (buf []byte, err error) {
+	if addr == 0 || size <= 0 || size > maxTableSize {
+		return nil, fmt.Errorf("invalid table (%#x, %d bytes)", addr, size)
+	}
+
+	r, err := dma.NewRegion(uint(addr), size, false)
+
+	if err != nil {
+		return
+	}
+
+	buf = make([]byte, size)
+	r.Read(uint(addr), 0, buf)
+
+	return
+}
+
+// readTable reads the ACPI table at the argument physical address.
+func readTable(addr uint64) (buf []byte, err error) {
+	if buf, err = readPhys(addr, sdtHeaderSize); err != nil {
+		return
+	}
+
+	return readPhys(addr, int(binary.LittleEndian.Uint32(buf[sdtLength:])))
+}
+
+// madt returns the Multiple APIC Description Table, located through the EFI
+// ACPI 2.0 Configuration Table.
+func madt() (buf []byte, err error) {
+	t, err := x64.UEFI.SystemTable.LocateConfiguration(EFI_ACPI_20_TABLE_GUID)
+
+	if err != nil {
+		return
+	}
+
+	rsdp, err := readPhys(t.VendorTable, rsdpSize)
+
+	if err != nil {
+		return
+	}
+
+	if string(rsdp[0:8]) != rsdpSignature {
+		return nil, errors.New("invalid RSDP")
+	}
+
+	xsdt, err := readTable(binary.LittleEndian.Uint64(rsdp[rsdpXSDT:]))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read XSDT, %v", err)
+	}
+
+	for off := sdtHeaderSize; off+8 <= len(xsdt); off += 8 {
+		addr := binary.LittleEndian.Uint64(xsdt[off:])
+
+		if buf, err = readTable(addr); err != nil {
+			return
+		}
+
+		if string(buf[0:4]) == madtSignature {
+			return
+		}
+	}
+
+	return nil, errors.New("could not find MADT")
+}
+
+// apicIDs returns the APIC IDs of all enabled processors, as listed in the
+// MADT, excluding the executing one.
+func apicIDs() (ids []int, err error) {
+	buf, err := madt()
+
+	if err != nil {
+		return
+	}
+
+	// current x2APIC ID
+	_, _, _, bsp := x64.AMD64.CPUID(0xb, 0)
+
+	for off := madtEntries; off+2 <= len(buf); {
+		var id, flags uint32
+
+		typ := buf[off]
+		size := int(buf[off+1])
+
+		if size < 2 || off+size > len(buf) {
+			return nil, errors.New("invalid MADT entry")
+		}
+
+		switch {
+		case typ == madtLocalAPIC && size >= 8:
+			id = uint32(buf[off+3])
+			flags = binary.LittleEndian.Uint32(buf[off+4:])
+		case typ == madtLocalX2APIC && size >= 16:
+			id = binary.LittleEndian.Uint32(buf[off+4:])
+			flags = binary.LittleEndian.Uint32(buf[off+8:])
+		}
+
+		if flags&madtEnabled != 0 && id != bsp && !slices.Contains(ids, int(id)) {
+			ids = append(ids, int(id))
+		}
+
+		off += size
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 98: Message Header Format.
+const (
+	headerVersion  = 0x1
+	headerSize     = 96
+	messageVersion = 0x1
+	authTagSize    = 16
+)
+
+// sealMessage encrypts a guest message with the argument VMPCK and sequence
+// number.
+func sealMessage(hdr *sev.MessageHeader, plaintext, key []byte, seq uint64) (msg []byte, err error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return
+	}
+
+	aesgcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return
+	}
+
+	hdr.MessageSize = uint16(len(plaintext))
+	hdr.SetSeq(seq)
+
+	ciphertext := aesgcm.Seal(nil, hdr.SeqNo[0:12], plaintext, hdr.Bytes()[48:])
+
+	tagOffset := len(ciphertext) - authTagSize
+	copy(hdr.AuthTag[:], ciphertext[tagOffset:])
+
+	msg = hdr.Bytes()
+	msg = append(msg, ciphertext[:tagOffset]...)
+
+	return
+}
+
+// openMessage decrypts a firmware response with the argument VMPCK, its
+// sequence number must match the expected one.
+func openMessage(buf []byte, key []byte, seq uint64) (plaintext []byte, err error) {
+	hdr := &sev.MessageHeader{}
+
+	if _, err = binary.Decode(buf, binary.LittleEndian, hdr); err != nil {
+		return nil, fmt.Errorf("could not parse response header, %v", err)
+	}
+
+	if hdr.Seq() != seq || headerSize+int(hdr.MessageSize) > len(buf) {
+		return nil, errors.New("invalid response header")
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return
+	}
+
+	aesgcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return
+	}
+
+	ciphertext := append([]byte{}, buf[headerSize:headerSize+int(hdr.MessageSize)]...)
+	ciphertext = append(ciphertext, hdr.AuthTag[:authTagSize]...)
+
+	// zero AuthTag header before unseal
+	copy(hdr.AuthTag[:], make([]byte, len(hdr.AuthTag)))
+
+	if plaintext, err = aesgcm.Open(nil, hdr.SeqNo[0:12], ciphertext, hdr.Bytes()[48:]); err != nil {
+		return nil, fmt.Errorf("could not decrypt response message, %v", err)
+	}
+
+	return
+}
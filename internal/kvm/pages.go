@@ -0,0 +1,256 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/usbarmory/tamago/dma"
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/pagestate"
+)
+
+// pages tracks the unikernel memory page state
+var pages struct {
+	sync.Mutex
+
+	bitmap *pagestate.Bitmap
+	// runtime allocated shared regions and their backing memory
+	regions map[*dma.Region][]byte
+	// backing memory of failed allocations, in unknown state
+	stranded [][]byte
+}
+
+// psc requests page state changes in chunks of at most [sev.MaxPSCEntries]
+// entries, on failure all requested pages are reverted.
+func psc(b *sev.GHCB, start uint64, end uint64, pageSize int, shared bool) (err error) {
+	size := uint64(pagestate.PageSize)
+
+	if pageSize == sev.PAGE_SIZE_2M {
+		size = pagestate.LargePageSize
+	}
+
+	chunk := sev.MaxPSCEntries * size
+
+	for s := start; s < end; s += chunk {
+		e := min(s+chunk, end)
+
+		if err = b.PageStateChange(s, e, pageSize, !shared); err == nil {
+			continue
+		}
+
+		// best effort rollback, including the failed chunk
+		for r := start; r < e; r += chunk {
+			b.PageStateChange(r, min(r+chunk, e), pageSize, shared)
+		}
+
+		return fmt.Errorf("could not change page state (%#x-%#x), %v", s, e, err)
+	}
+
+	return
+}
+
+// pageStateChange assigns the argument range as private or shared, 2M pages
+// are preferred when the range is aligned, falling back to 4K pages as the
+// hypervisor RMP might be fragmented.
+func pageStateChange(b *sev.GHCB, start uint64, end uint64, shared bool) (large bool, err error) {
+	if start%pagestate.LargePageSize == 0 && end%pagestate.LargePageSize == 0 {
+		if err = psc(b, start, end, sev.PAGE_SIZE_2M, shared); err == nil {
+			return true, nil
+		}
+	}
+
+	return false, psc(b, start, end, sev.PAGE_SIZE_4K, shared)
+}
+
+// transition validates/invalidates and changes encryption of the argument
+// range, which must be entirely in the opposite state, the operation is
+// rolled back on failure.
+func transition(b *sev.GHCB, start uint64, end uint64, shared bool) (err error) {
+	pages.Lock()
+	defer pages.Unlock()
+
+	if pages.bitmap != nil {
+		if ok, err := pages.bitmap.Uniform(start, end, !shared); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("range (%#x-%#x) not in expected state", start, end)
+		}
+	}
+
+	cbit := Features.EncryptedBit
+
+	// C-bit transitions must precede validation
+	if !shared {
+		if err = x64.AMD64.SetEncryptedBit(start, end, cbit, true); err != nil {
+			return
+		}
+	}
+
+	large, err := pageStateChange(b, start, end, shared)
+
+	if err != nil {
+		if !shared {
+			x64.AMD64.SetEncryptedBit(start, end, cbit, false)
+		}
+
+		return
+	}
+
+	// C-bit transitions must follow invalidation
+	if shared {
+		if err = x64.AMD64.SetEncryptedBit(start, end, cbit, false); err != nil {
+			pageStateChange(b, start, end, false)
+			return
+		}
+	}
+
+	if pages.bitmap != nil {
+		err = pages.bitmap.Set(start, end, shared, large)
+	}
+
+	return
+}
+
+// PageState returns the tracked unikernel memory ranges and their state.
+func PageState() (ranges []pagestate.Range, err error) {
+	pages.Lock()
+	defer pages.Unlock()
+
+	if pages.bitmap == nil {
+		return nil, ErrNotPresent
+	}
+
+	return pages.bitmap.Ranges(), nil
+}
+
+// SharedRegions returns the shared DMA regions allocated at runtime (see
+// [GrowShared]).
+func SharedRegions() (regions []*dma.Region) {
+	pages.Lock()
+	defer pages.Unlock()
+
+	for r := range pages.regions {
+		regions = append(regions, r)
+	}
+
+	return
+}
+
+// allocShared allocates, from unikernel memory, a shared region of the
+// argument size (rounded up to 2M).
+func allocShared(size int) (r *dma.Region, buf []byte, err error) {
+	if GHCB == nil {
+		return nil, nil, ErrNotPresent
+	}
+
+	if size <= 0 {
+		return nil, nil, errors.New("invalid size")
+	}
+
+	size = (size + pagestate.LargePageSize - 1) &^ (pagestate.LargePageSize - 1)
+	buf, addr := alloc(size, pagestate.LargePageSize)
+
+	err = WithGHCB(func(b *sev.GHCB) error {
+		return transition(b, addr, addr+uint64(size), true)
+	})
+
+	if err != nil {
+		release(buf, addr, size)
+		return nil, nil, err
+	}
+
+	// the region is carved from Go runtime memory, hence unsafe
+	if r, err = dma.NewRegion(uint(addr), size, true); err != nil {
+		WithGHCB(func(b *sev.GHCB) error {
+			return transition(b, addr, addr+uint64(size), false)
+		})
+
+		release(buf, addr, size)
+
+		return nil, nil, err
+	}
+
+	return
+}
+
+// release drops the backing memory of a failed shared allocation, unless
+// any of its pages might still be shared, in which case it is retained to
+// prevent its reuse by the Go runtime.
+func release(buf []byte, addr uint64, size int) {
+	pages.Lock()
+	defer pages.Unlock()
+
+	if pages.bitmap != nil {
+		if ok, err := pages.bitmap.Uniform(addr, addr+uint64(size), false); err == nil && ok {
+			return
+		}
+	}
+
+	pages.stranded = append(pages.stranded, buf)
+}
+
+// GrowShared allocates, from unikernel memory, an additional shared region of
+// the argument size (rounded up to 2M) for DMA use.
+func GrowShared(size int) (r *dma.Region, err error) {
+	r, buf, err := allocShared(size)
+
+	if err != nil {
+		return
+	}
+
+	pages.Lock()
+	defer pages.Unlock()
+
+	if pages.regions == nil {
+		pages.regions = make(map[*dma.Region][]byte)
+	}
+
+	pages.regions[r] = buf
+
+	return
+}
+
+// ShrinkShared releases a shared region allocated with [GrowShared], its
+// memory is returned to private state and cleared. The region must not have
+// any allocated buffers.
+func ShrinkShared(r *dma.Region) (err error) {
+	pages.Lock()
+	buf, ok := pages.regions[r]
+	pages.Unlock()
+
+	if !ok {
+		return errors.New("invalid region")
+	}
+
+	if len(r.UsedBlocks()) > 0 {
+		return errors.New("region in use")
+	}
+
+	start := uint64(r.Start())
+	end := uint64(r.End())
+
+	err = WithGHCB(func(b *sev.GHCB) error {
+		return transition(b, start, end, false)
+	})
+
+	if err != nil {
+		return
+	}
+
+	clear(buf)
+
+	pages.Lock()
+	delete(pages.regions, r)
+	pages.Unlock()
+
+	return
+}
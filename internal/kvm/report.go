@@ -0,0 +1,91 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+)
+
+// Report requests an attestation report for this VM, the argument data is
+// embedded as REPORT_DATA to bind the report to verifier chosen values.
+func Report(data []byte) (report *sev.AttestationReport, err error) {
+	report, _, err = attestationReport(data, false)
+	return
+}
+
+// ReportDigest requests an attestation report for this VM, the SHA-512 digest
+// of the argument data is embedded as REPORT_DATA to bind the report to
+// verifier chosen content of arbitrary size (e.g. a challenge file).
+func ReportDigest(data []byte) (report *sev.AttestationReport, err error) {
+	sum := sha512.Sum512(data)
+	return Report(sum[:])
+}
+
+// ExtendedReport requests an attestation report for this VM, the argument
+// data is embedded as REPORT_DATA to bind the report to verifier chosen
+// values.
+//
+// The report is requested with an SNP Extended Guest Request, which also
+// returns the certificate table supplied by the hypervisor (see
+// [attest.ParseCertTable]), this is empty if no certificates are provided.
+func ExtendedReport(data []byte) (report *sev.AttestationReport, certs []byte, err error) {
+	return attestationReport(data, true)
+}
+
+func attestationReport(data []byte, ext bool) (report *sev.AttestationReport, certs []byte, err error) {
+	var buf []byte
+
+	if len(data) > 64 {
+		return nil, nil, fmt.Errorf("invalid report data size (%d > 64)", len(data))
+	}
+
+	req := &sev.ReportRequest{
+		// the report VMPL must not be lower than the current one
+		VMPL: uint32(VMPL()),
+	}
+	copy(req.Data[:], data)
+
+	if buf, certs, err = request(sev.MSG_REPORT_REQ, req.Bytes(), ext); err != nil {
+		return
+	}
+
+	res := &sev.ReportResponse{}
+
+	if _, err = binary.Decode(buf, binary.LittleEndian, res); err != nil {
+		return nil, nil, fmt.Errorf("could not parse response, %v", err)
+	}
+
+	if res.Status != 0 {
+		return nil, nil, fmt.Errorf("request error, %#x", res.Status)
+	}
+
+	return &res.Report, certs, nil
+}
+
+// TSCInfo requests TSC information for this VM.
+func TSCInfo() (info *sev.TSCInfo, err error) {
+	var buf []byte
+
+	if buf, _, err = request(sev.MSG_TSC_INFO_REQ, make([]byte, 128), false); err != nil {
+		return
+	}
+
+	info = &sev.TSCInfo{}
+
+	if _, err = binary.Decode(buf, binary.LittleEndian, info); err != nil {
+		return nil, fmt.Errorf("could not parse response, %v", err)
+	}
+
+	if info.Status != 0 {
+		return nil, fmt.Errorf("request error, %#x", info.Status)
+	}
+
+	return
+}
@@ -0,0 +1,203 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// sealed blob format
+const (
+	sealMagic   = "SEAL"
+	sealVersion = 1
+	sealLabel   = "sealing-key/aes-256-gcm/v1"
+)
+
+// sealHeader represents the sealed blob header, which carries the key
+// derivation policy required for unsealing and is authenticated as
+// additional data.
+type sealHeader struct {
+	Magic           [4]byte
+	Version         uint8
+	_               [3]byte
+	Root            uint32
+	_               uint32
+	Fields          uint64
+	VMPL            uint32
+	GuestSVN        uint32
+	TCBVersion      uint64
+	LaunchMitVector uint64
+	Mix             uint64
+	Nonce           [12]byte
+}
+
+// Bytes converts the descriptor structure to byte array format.
+func (h *sealHeader) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, h)
+	return buf.Bytes()
+}
+
+func (h *sealHeader) policy() *KeyPolicy {
+	return &KeyPolicy{
+		Root:            KeyRoot(h.Root),
+		Fields:          KeyField(h.Fields),
+		VMPL:            h.VMPL,
+		GuestSVN:        h.GuestSVN,
+		TCBVersion:      h.TCBVersion,
+		LaunchMitVector: h.LaunchMitVector,
+		Mix:             h.Mix,
+	}
+}
+
+// SealPolicy returns the default sealing policy, which extends
+// [DefaultKeyPolicy] with binding to the current reported TCB version.
+//
+// As the firmware only allows derivation for TCB versions not exceeding the
+// current one, data sealed with this policy cannot be unsealed after a TCB
+// rollback while it survives TCB updates.
+func SealPolicy() (policy *KeyPolicy, err error) {
+	report, err := Report(nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get report, %v", err)
+	}
+
+	p := *DefaultKeyPolicy
+	p.Fields |= KeyFieldTCBVersion
+	p.TCBVersion = report.ReportedTCB
+
+	return &p, nil
+}
+
+// check verifies that the header policy, which is not trusted until
+// authenticated, is not weaker than the expected one.
+func (h *sealHeader) check(expected *KeyPolicy) error {
+	switch {
+	case KeyField(h.Fields)&KeyFieldMeasurement == 0:
+		return errors.New("sealed blob not bound to measurement")
+	case KeyRoot(h.Root) != expected.Root:
+		return fmt.Errorf("sealed blob root key mismatch (%#x)", h.Root)
+	case KeyField(h.Fields) != expected.Fields:
+		return fmt.Errorf("sealed blob fields mismatch (%#x)", h.Fields)
+	case h.VMPL != expected.VMPL:
+		return fmt.Errorf("sealed blob VMPL mismatch (%d)", h.VMPL)
+	case h.Mix != expected.Mix:
+		return fmt.Errorf("sealed blob mix mismatch (%#x)", h.Mix)
+	}
+
+	return nil
+}
+
+func sealCipher(policy *KeyPolicy) (aead cipher.AEAD, err error) {
+	key, err := DeriveKey(policy, sealLabel)
+
+	if err != nil {
+		return
+	}
+
+	defer clear(key)
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and authenticates data with AES-256-GCM, under a key derived
+// according to the argument policy (see [SealPolicy] when nil), which must
+// include [KeyFieldMeasurement]. The returned blob embeds the policy and can
+// only be unsealed, with [Unseal], by guests able to derive the same key.
+func Seal(policy *KeyPolicy, data []byte) (blob []byte, err error) {
+	if policy == nil {
+		if policy, err = SealPolicy(); err != nil {
+			return
+		}
+	}
+
+	if policy.Fields&KeyFieldMeasurement == 0 {
+		return nil, errors.New("policy not bound to measurement")
+	}
+
+	hdr := &sealHeader{
+		Version:         sealVersion,
+		Root:            uint32(policy.Root),
+		Fields:          uint64(policy.Fields),
+		VMPL:            policy.VMPL,
+		GuestSVN:        policy.GuestSVN,
+		TCBVersion:      policy.TCBVersion,
+		LaunchMitVector: policy.LaunchMitVector,
+		Mix:             policy.Mix,
+	}
+
+	copy(hdr.Magic[:], sealMagic)
+	rand.Read(hdr.Nonce[:])
+
+	aead, err := sealCipher(policy)
+
+	if err != nil {
+		return
+	}
+
+	blob = hdr.Bytes()
+
+	return aead.Seal(blob, hdr.Nonce[:], data, blob), nil
+}
+
+// Unseal authenticates and decrypts a blob created with [Seal] under the
+// argument policy (see [SealPolicy] when nil).
+//
+// The blob header policy must match the expected root key, guest fields and
+// VMPL, while its TCB version can be lower than the expected one to allow
+// unsealing after TCB updates.
+func Unseal(policy *KeyPolicy, blob []byte) (data []byte, err error) {
+	hdr := &sealHeader{}
+
+	if policy == nil {
+		if policy, err = SealPolicy(); err != nil {
+			return
+		}
+	}
+
+	n, err := binary.Decode(blob, binary.LittleEndian, hdr)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse header, %v", err)
+	}
+
+	if string(hdr.Magic[:]) != sealMagic {
+		return nil, errors.New("invalid sealed blob")
+	}
+
+	if hdr.Version != sealVersion {
+		return nil, fmt.Errorf("unsupported sealed blob version (%d)", hdr.Version)
+	}
+
+	if err = hdr.check(policy); err != nil {
+		return
+	}
+
+	aead, err := sealCipher(hdr.policy())
+
+	if err != nil {
+		return
+	}
+
+	if data, err = aead.Open(nil, hdr.Nonce[:], blob[n:], blob[:n]); err != nil {
+		return nil, fmt.Errorf("could not unseal, %v", err)
+	}
+
+	return
+}
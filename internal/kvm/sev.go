@@ -7,12 +7,15 @@ package kvm
 
 import (
 	"fmt"
+	"runtime"
+	"runtime/goos"
 
-	"github.com/usbarmory/tamago/amd64"
 	"github.com/usbarmory/tamago/dma"
 	"github.com/usbarmory/tamago/kvm/sev"
 
 	"github.com/usbarmory/go-boot/uefi/x64"
+
+	"github.com/usbarmory/tamago-sev-example/internal/pagestate"
 )
 
 var (
@@ -21,7 +24,12 @@ var (
 	GHCB     map[uint64]*sev.GHCB
 )
 
+// layouts maps each vCPU to its unikernel owned GHCB GPA
+var layouts map[uint64]uint64
+
 func initSharedDMA(ghcb *sev.GHCB, dmaSize int) (err error) {
+	ramStart, _ := runtime.MemRegion()
+
 	// align to 2MB page
 	dmaStart := int(x64.RamSize) - dmaSize
 	dmaSize += dmaStart % (2 << 20)
@@ -33,18 +41,13 @@ func initSharedDMA(ghcb *sev.GHCB, dmaSize int) (err error) {
 	start := uint64(dma.Default().Start())
 	end := uint64(dma.Default().End())
 
-	// Invalidate memory before clearing encryption, do it in chunks to
-	// avoid dealing with hypervisor RMP fragmentation.
-	chunk := sev.MaxPSCEntries * uint64(4096)
-
-	for s := start; s < end; s += chunk {
-		if err = ghcb.PageStateChange(start, start+chunk, sev.PAGE_SIZE_4K, false); err != nil {
-			return
-		}
+	// track unikernel memory, including the DMA region, page state
+	if pages.bitmap, err = pagestate.New(ramStart&^(pageSize-1), end); err != nil {
+		return
 	}
 
-	// disable encryption for DMA region
-	return x64.AMD64.SetEncryptedBit(start, end, Features.EncryptedBit, false)
+	// invalidate memory and disable encryption for DMA region
+	return transition(ghcb, start, end, true)
 }
 
 func InitGHCB() (err error) {
@@ -72,29 +75,56 @@ func InitGHCB() (err error) {
 		return fmt.Errorf(" could not initialize AMD SEV-SNP secrets, %v", err)
 	}
 
-	// map GHCB <> vCPU
-	GHCB = make(map[uint64]*sev.GHCB)
+	secretsAddr = uint(snp.SecretsPagePhysicalAddress)
+	cpuidAddr = snp.CPUIDPagePhysicalAddress
 
-	// initialize vCPU0 GHCB for DMA allocation
-	GHCB[0] = &sev.GHCB{
+	// The shared DMA region is allocated using the OVMF GHCB GPA, as the
+	// unikernel GHCB pages are in turn allocated within such region.
+	boot := &sev.GHCB{
 		CPU: x64.AMD64,
 	}
 
 	// allocate unencrypted region for GHCB.GuestRequest and driver use
-	if err = initSharedDMA(GHCB[0], 10<<20); err != nil {
+	if err = initSharedDMA(boot, 10<<20); err != nil {
 		return fmt.Errorf("could not allocate shared DMA region, %v", err)
 	}
 
-	// finish VCPUs instance creation
+	// The GHCB maps are keyed, as looked up by WithGHCB, by APIC ID
+	// which is not necessarily contiguous, all enabled processors listed
+	// in the MADT are mapped (see InitSMP).
+	ids, err := apicIDs()
 
-	GHCB[0].Region = dma.Default()
+	if err != nil {
+		return fmt.Errorf("could not find APIC IDs, %v", err)
+	}
 
-	for n := uint64(1); n < uint64(amd64.NumCPU()); n++ {
+	// map GHCB <> vCPU
+	GHCB = make(map[uint64]*sev.GHCB)
+	layouts = make(map[uint64]uint64)
+
+	for _, id := range append([]int{int(goos.ProcID())}, ids...) {
+		n := uint64(id)
+
+		// dedicated page, never released, for each vCPU GHCB layout
+		addr, buf := dma.Default().Reserve(pageSize, pageSize)
+		clear(buf)
+
+		// The Layout is left empty as it is initialized, by the first
+		// exit within WithGHCB, from the registered GHCB GPA.
 		GHCB[n] = &sev.GHCB{
-			CPU: x64.AMD64,
+			CPU:    x64.AMD64,
+			Region: dma.Default(),
 		}
-		GHCB[n].Region = dma.Default()
+
+		layouts[n] = uint64(addr)
 	}
 
+	registerTeardown()
+
 	return
 }
+
+// GHCBAddress returns the unikernel owned GHCB GPA for the argument vCPU.
+func GHCBAddress(n uint64) uint64 {
+	return layouts[n]
+}
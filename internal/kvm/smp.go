@@ -6,40 +6,217 @@
 package kvm
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
 
 	"github.com/usbarmory/tamago/amd64"
+	"github.com/usbarmory/tamago/amd64/lapic"
+	"github.com/usbarmory/tamago/dma"
 	"github.com/usbarmory/tamago/kvm/sev"
 
 	"github.com/usbarmory/go-boot/uefi/x64"
 )
 
-// TODO: WiP
-func CreateAPs() {
+const (
+	// AP task address (see [amd64.CPU.Task])
+	taskAddress = 0x6020
+
+	// Intel Local Advanced Programmable Interrupt Controller
+	lapicSVR  = amd64.LAPIC_BASE + lapic.LAPIC_SVR
+	svrEnable = lapic.SVR_ENABLE
+
+	// AP stack size for its idle state, tasks use their own stack
+	apStackSize = 4096
+)
+
+// AMD64 Architecture Programmer’s Manual
+// Volume 2 - 3.1.7 Extended Feature Enable Register (EFER).
+const (
+	msrEFER  = 0xc0000080
+	eferSVME = 1 << 12
+)
+
+// AMD64 Architecture Programmer’s Manual
+// Volume 2 - Table B-2 (VMCB segment attributes).
+const (
+	codeAttrib = 0x029b // Present, Code, Executable, Read, Accessed, Long
+	dataAttrib = 0x0c93 // Present, Data, Read/Write, Accessed, 32-bit, 4K
+)
+
+// task represents a CPU task
+type task struct {
+	sp uint64 // stack pointer
+	gp uint64 // G
+	pc uint64 // fn
+}
+
+// apMemory holds AP stacks and VMSA pages, which are never released
+var apMemory [][]byte
+
+// defined in smp.s
+func cr0() (val uint64)
+func cr3() (val uint64)
+func sgdt() (addr uint64, limit uint16)
+func apstartAddr() (addr uint64)
+
+// alloc returns a buffer of the argument size and physical memory alignment.
+func alloc(size int, align int) (buf []byte, addr uint64) {
+	buf = make([]byte, size+align)
+	base := uint64(uintptr(unsafe.Pointer(&buf[0])))
+	addr = (base + uint64(align) - 1) &^ (uint64(align) - 1)
+
+	return buf[addr-base:][:size], addr
+}
+
+// apVMSA returns a VMSA for an AP to start in 64-bit Long Mode with the BSP
+// paging, GDT and IDT, using a dedicated stack.
+func apVMSA() (v *sev.VMSA) {
+	v = &sev.VMSA{}
+	v.Init(0)
+
+	code := sev.Segment{
+		Selector: 1 << 3,
+		Attrib:   codeAttrib,
+		Limit:    0xffffffff,
+	}
+
+	data := sev.Segment{
+		Selector: 2 << 3,
+		Attrib:   dataAttrib,
+		Limit:    0xffffffff,
+	}
+
+	v.CS = code
+	v.DS = data
+	v.ES = data
+	v.FS = data
+	v.GS = data
+	v.SS = data
+
+	gdt, gdtLimit := sgdt()
+	idt, idtLimit := sidt()
+
+	v.GDTR = sev.Segment{Base: gdt, Limit: uint32(gdtLimit)}
+	v.IDTR = sev.Segment{Base: idt, Limit: uint32(idtLimit)}
+
+	v.CR0 = cr0()
+	v.CR3 = cr3()
+	v.CR4 = cr4()
+	v.EFER = rdmsr(msrEFER) | eferSVME
+
+	if v.CR4&cr4OSXSAVE != 0 {
+		v.XCR0 = xgetbv()
+	}
+
+	buf, stack := alloc(apStackSize, 16)
+	apMemory = append(apMemory, buf)
+
+	v.RIP = apstartAddr()
+	v.RSP = stack + apStackSize
+
+	// match current features
+	v.SEV_FEATURES = Features.SEV.Features
+
+	return
+}
+
+// vmsaRegion returns a page for VMSA use, the page is not 2MB aligned as
+// required by AMD SEV-SNP (erratum 1467).
+func vmsaRegion() (r *dma.Region, err error) {
+	buf, addr := alloc(2*pageSize, pageSize)
+	apMemory = append(apMemory, buf)
+
+	if addr%(2<<20) == 0 {
+		addr += pageSize
+	}
+
+	return dma.NewRegion(uint(addr), pageSize, false)
+}
+
+// InitSMP enables Secure Multiprocessor (SMP) operation by creating, through
+// SNP AP Creation events, Application Processors with unikernel populated
+// VMSAs, as an alternative to [x64.InitSMP] which relies on EFI MP services.
+//
+// A positive argument caps the total (BSP+APs) number of cores, a negative
+// argument initializes all enabled processors listed in the ACPI MADT.
+//
+// As APs never run firmware code they require the unikernel exception and
+// #VC (see [EnableVC]) handlers, which replace the firmware ones, therefore
+// EFI boot services must be exited first.
+func InitSMP(n int) (err error) {
+	var features uint64
+
 	if GHCB == nil {
+		return ErrNotPresent
+	}
+
+	if x64.Console.Out != 0 {
+		return errors.New("EFI boot services must be exited first")
+	}
+
+	err = WithGHCB(func(b *sev.GHCB) (err error) {
+		features, err = b.HypervisorFeatures()
 		return
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not request hypervisor features, %v", err)
 	}
 
-	ncpu := amd64.NumCPU()
+	if features&(1<<sev.FeatureAPCreation) == 0 {
+		return errors.New("AP creation not supported")
+	}
 
-	for i := 1; i < ncpu; i++ {
-		if err := GHCB[0].RemoveAP(i); err != nil {
-			log.Printf("could not stop AP%d, %v", i, err)
-			return
+	ids, err := apicIDs()
+
+	if err != nil {
+		return fmt.Errorf("could not find APIC IDs, %v", err)
+	}
+
+	if n > 0 && n-1 < len(ids) {
+		ids = ids[:n-1]
+	}
+
+	ncpu := len(ids) + 1
+
+	// trap CPU exceptions
+	x64.AMD64.EnableExceptions()
+
+	if err = EnableVC(); err != nil {
+		return fmt.Errorf("could not enable #VC handler, %v", err)
+	}
+
+	// clear counting semaphore and task
+	for off := uint64(0); off < 3*8; off += 8 {
+		write64(taskAddress+off, 0)
+	}
+
+	for _, id := range ids {
+		vmsa := apVMSA()
+		r, err := vmsaRegion()
+
+		if err != nil {
+			return err
 		}
 
-		// set VMSA defaults
-		vmsa := &sev.VMSA{}
-		vmsa.Init(0)
+		err = WithGHCB(func(b *sev.GHCB) (err error) {
+			// remove firmware initialized vCPU, if any
+			b.RemoveAP(id)
 
-		// match current features
-		vmsa.SEV_FEATURES = sev.Features(x64.AMD64).SEV.Features
+			b.VMSA = r
+			return b.CreateAP(id, vmsa)
+		})
 
-		if err := GHCB[0].CreateAP(1, vmsa); err != nil {
-			log.Printf("could not create AP%d, %v", 1, err)
-			return
+		if err != nil {
+			return fmt.Errorf("could not create AP (APIC ID %d), %v", id, err)
 		}
 	}
 
-	x64.AMD64.InitSMP(-1)
+	if x64.AMD64.GOMAXPROCS(ncpu); runtime.GOMAXPROCS(-1) != ncpu {
+		return errors.New("APs not ready")
+	}
+
+	return
 }
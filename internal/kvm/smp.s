@@ -0,0 +1,77 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "go_asm.h"
+#include "textflag.h"
+
+// func cr0() (val uint64)
+TEXT ·cr0(SB),NOSPLIT,$0-8
+	MOVQ	CR0, AX
+	MOVQ	AX, val+0(FP)
+	RET
+
+// func cr3() (val uint64)
+TEXT ·cr3(SB),NOSPLIT,$0-8
+	MOVQ	CR3, AX
+	MOVQ	AX, val+0(FP)
+	RET
+
+// func sgdt() (addr uint64, limit uint16)
+TEXT ·sgdt(SB),NOSPLIT,$0-10
+	SUBQ	$16, SP
+	SGDT	(SP)
+	MOVW	0(SP), BX
+	MOVQ	2(SP), AX
+	ADDQ	$16, SP
+	MOVQ	AX, addr+0(FP)
+	MOVW	BX, limit+8(FP)
+	RET
+
+// func apstartAddr() (addr uint64)
+TEXT ·apstartAddr(SB),NOSPLIT,$0-8
+	MOVQ	$·apstart(SB), AX
+	MOVQ	AX, addr+0(FP)
+	RET
+
+// The AP entry point, in 64-bit Long Mode with BSP paging, GDT and IDT as set
+// in its VMSA, reaches the same idle state of [amd64.CPU.InitSMP] APs.
+TEXT ·apstart(SB),NOSPLIT|NOFRAME,$0
+	// use taskAddress as counting semaphore for SMP enabling
+	MOVQ	$(const_taskAddress), BX
+	MOVL	$1, AX
+	LOCK
+	XADDL	AX, 0(BX)
+wait:
+	// wait NMI from CPU.Task
+	CLI
+	HLT
+
+	MOVQ	$(const_taskAddress), AX
+	MOVQ	task_pc(AX), R12
+	CMPQ	R12, $0
+	JE	wait
+
+	MOVQ	task_sp(AX), SP
+	MOVQ	task_gp(AX), g
+
+	// clear task
+	MOVQ	$0, task_sp(AX)
+	MOVQ	$0, task_gp(AX)
+	MOVQ	$0, task_pc(AX)
+
+	MOVQ	g, DI
+	CALL	runtime·settls(SB)
+	MOVQ	g, (TLS)
+
+	// enable LAPIC
+	MOVL	$(const_lapicSVR), AX
+	MOVL	$(1<<const_svrEnable), (AX)	// set SVR_ENABLE
+
+	// call task target
+	STI
+	CALL	R12
+
+	// go back to idle state in case we return
+	JMP wait
@@ -0,0 +1,155 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/goos"
+	"sync"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/tamago-sev-example/internal/svsm"
+)
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 2.3.1 GHCB MSR Protocol, SNP Run VMPL Request.
+const (
+	msrRunVMPLRequest  = 0x016
+	msrRunVMPLResponse = 0x017
+)
+
+// svsmArea represents the Secrets Page SVSM fields
+// (Secure VM Service Module for SEV-SNP Guests - Table 1).
+type svsmArea struct {
+	Base       uint64
+	Size       uint64
+	CAA        uint64
+	MaxVersion uint32
+	GuestVMPL  uint8
+	_          [3]byte
+}
+
+// SVSM represents the Secure VM Service Module information.
+type SVSM struct {
+	// Base is the SVSM memory base address.
+	Base uint64
+	// Size is the SVSM memory size.
+	Size uint64
+	// CAA is the BSP Calling Area physical address.
+	CAA uint64
+	// MaxVersion is the maximum supported SVSM protocol version.
+	MaxVersion uint32
+	// VMPL is the guest VM Privilege Level.
+	VMPL uint8
+}
+
+// defined in svsm.s
+func svsmcall(caa uint64, rax uint64, rcx uint64, rdx uint64, r8 uint64, r9 uint64) (orax uint64, orcx uint64, ordx uint64, or8 uint64)
+
+// svsmLock serializes SVSM calls, which use the BSP Calling Area
+var svsmLock sync.Mutex
+
+// SVSMInfo returns the Secure VM Service Module information, nil when the
+// unikernel is not running under an SVSM.
+func SVSMInfo() *SVSM {
+	a := &svsmArea{}
+
+	if Secrets == nil {
+		return nil
+	}
+
+	if _, err := binary.Decode(Secrets.GuestArea2[:], binary.LittleEndian, a); err != nil || a.CAA == 0 {
+		return nil
+	}
+
+	return &SVSM{
+		Base:       a.Base,
+		Size:       a.Size,
+		CAA:        a.CAA,
+		MaxVersion: a.MaxVersion,
+		VMPL:       a.GuestVMPL,
+	}
+}
+
+// VMPL returns the current VM Privilege Level, which is 0 unless running
+// under an SVSM.
+func VMPL() int {
+	if s := SVSMInfo(); s != nil {
+		return int(s.VMPL)
+	}
+
+	return 0
+}
+
+// SVSMCall issues an SVSM call, through the SNP Run VMPL request of the GHCB
+// MSR protocol, with the argument protocol, call identifier and RCX, RDX, R8,
+// R9 register values.
+//
+// As only the BSP Calling Area is known, calls are issued from vCPU 0.
+func SVSMCall(protocol uint32, call uint32, rcx, rdx, r8, r9 uint64) (orcx, ordx, or8 uint64, err error) {
+	s := SVSMInfo()
+
+	if s == nil {
+		return 0, 0, 0, errors.New("SVSM not present")
+	}
+
+	svsmLock.Lock()
+	defer svsmLock.Unlock()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if goos.ProcID != nil && goos.ProcID() != 0 {
+		return 0, 0, 0, errors.New("SVSM calls are only supported on vCPU 0")
+	}
+
+	prev := rdmsr(sev.MSR_AMD_GHCB)
+	defer wrmsr(sev.MSR_AMD_GHCB, prev)
+
+	// request execution at VMPL0
+	wrmsr(sev.MSR_AMD_GHCB, msrRunVMPLRequest)
+
+	rax := uint64(protocol)<<32 | uint64(call)
+	res, orcx, ordx, or8 := svsmcall(s.CAA, rax, rcx, rdx, r8, r9)
+
+	if val := rdmsr(sev.MSR_AMD_GHCB); val&msrInfoMask != msrRunVMPLResponse || val>>32 != 0 {
+		return 0, 0, 0, fmt.Errorf("could not run VMPL0 (%#x)", val)
+	}
+
+	if read8(s.CAA) != 0 {
+		return 0, 0, 0, errors.New("SVSM call still pending")
+	}
+
+	if res != svsm.SVSM_SUCCESS {
+		err = svsm.Result(res)
+	}
+
+	return
+}
+
+// svsmCaller implements [svsm.Caller] through [SVSMCall].
+type svsmCaller struct{}
+
+func (svsmCaller) Call(protocol uint32, call uint32, rcx, rdx, r8, r9 uint64) (orcx, ordx, or8 uint64, err error) {
+	return SVSMCall(protocol, call, rcx, rdx, r8, r9)
+}
+
+// SVSMQueryProtocol returns the minimum and maximum versions supported by the
+// SVSM for the argument protocol, both are zero when the protocol is not
+// supported.
+func SVSMQueryProtocol(protocol uint32, version uint32) (minVersion uint16, maxVersion uint16, err error) {
+	return svsm.QueryProtocol(svsmCaller{}, protocol, version)
+}
+
+// SVSMAttest requests, through the SVSM attestation protocol, an attestation
+// report for all SVSM services (see [svsm.Attest]).
+func SVSMAttest(nonce []byte) (a *svsm.Attestation, err error) {
+	return svsm.Attest(svsmCaller{}, alloc, nonce)
+}
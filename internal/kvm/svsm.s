@@ -0,0 +1,30 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func svsmcall(caa uint64, rax uint64, rcx uint64, rdx uint64, r8 uint64, r9 uint64) (orax uint64, orcx uint64, ordx uint64, or8 uint64)
+TEXT ·svsmcall(SB),NOSPLIT,$0-80
+	MOVQ	caa+0(FP), BX
+	MOVQ	rax+8(FP), AX
+	MOVQ	rcx+16(FP), CX
+	MOVQ	rdx+24(FP), DX
+	MOVQ	r8+32(FP), R8
+	MOVQ	r9+40(FP), R9
+
+	// set call pending
+	MOVB	$1, (BX)
+
+	// vmgexit
+	BYTE	$0xf3
+	BYTE	$0x0f
+	BYTE	$0x01
+	BYTE	$0xd9
+
+	MOVQ	AX, orax+48(FP)
+	MOVQ	CX, orcx+56(FP)
+	MOVQ	DX, ordx+64(FP)
+	MOVQ	R8, or8+72(FP)
+	RET
@@ -0,0 +1,88 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"errors"
+
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/tamago-sev-example/internal/teardown"
+)
+
+// errTeardown is the invalidation error of VMPCKs wiped on teardown.
+var errTeardown = errors.New("wiped on teardown")
+
+// scrub clears the argument physical memory range.
+func scrub(addr uint, size int) (err error) {
+	// temporary view on memory, used only for its clearing
+	r, err := dma.NewRegion(addr, size, true)
+
+	if err != nil {
+		return
+	}
+
+	_, buf := r.Reserve(size, 0)
+	clear(buf)
+
+	return
+}
+
+// ScrubShared clears all shared memory regions, including the default DMA
+// region. Any buffer within such regions, including GHCB pages, is cleared
+// as well, therefore it must only be used on teardown.
+//
+// The guest message manager lock is held throughout, so that no guest
+// request is in flight on its GHCB and bounce pages while they are cleared.
+func ScrubShared() (err error) {
+	var errs []error
+
+	vmpcks.Lock()
+	defer vmpcks.Unlock()
+
+	regions := SharedRegions()
+
+	if r := dma.Default(); r != nil {
+		regions = append(regions, r)
+	}
+
+	bouncePool.Lock()
+
+	if bouncePool.pool != nil {
+		regions = append(regions, bouncePool.pool.Region)
+	}
+
+	bouncePool.Unlock()
+
+	for _, r := range regions {
+		errs = append(errs, scrub(r.Start(), int(r.Size())))
+	}
+
+	return errors.Join(errs...)
+}
+
+// WipeVMPCK invalidates all VM Communication Keys, wiping them from both the
+// Secrets Page and its copy, guest requests are no longer possible
+// afterwards.
+func WipeVMPCK() (err error) {
+	vmpcks.Lock()
+	defer vmpcks.Unlock()
+
+	if Secrets == nil {
+		return
+	}
+
+	initVMPCK()
+	vmpcks.DisableAll(errTeardown)
+
+	return
+}
+
+func registerTeardown() {
+	teardown.Register("zero derived keys", teardown.StageKeys, ZeroKeys)
+	teardown.Register("scrub shared memory", teardown.StageShared, ScrubShared)
+	teardown.Register("wipe VMPCKs", teardown.StageSecrets, WipeVMPCK)
+}
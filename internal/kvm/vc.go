@@ -0,0 +1,698 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"sync/atomic"
+
+	"github.com/usbarmory/tamago/amd64"
+	"github.com/usbarmory/tamago/dma"
+	"github.com/usbarmory/tamago/kvm/sev"
+)
+
+// VMM Communication Exception vector
+const vectorVC = 29
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 2.6 GHCB Layout.
+const (
+	ghcbRCX  = 0x0308
+	ghcbRDX  = 0x0310
+	ghcbXCR0 = 0x03e8
+)
+
+// AMD64 Architecture Programmer’s Manual
+// Volume 2 - Appendix C SVM Intercept Exit Codes.
+const (
+	exitRDTSC  = 0x6e
+	exitCPUID  = 0x72
+	exitIOIO   = 0x7b
+	exitMSR    = 0x7c
+	exitRDTSCP = 0x87
+	exitNPF    = 0x400
+)
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// Table 7: List of Supported Non-Automatic Events.
+const (
+	exitMMIORead  = 0x80000001
+	exitMMIOWrite = 0x80000002
+)
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 4.1.2 IOIO Exit Information.
+const (
+	ioioTypeIn = 1 << 0
+	ioioSize8  = 1 << 4
+	ioioSize16 = 1 << 5
+	ioioSize32 = 1 << 6
+	ioioAddr64 = 1 << 9
+)
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 2.3.1 GHCB MSR Protocol, Termination Request.
+const msrTerminateRequest = 0x100
+
+// CR4 OS Support for XSAVE
+const cr4OSXSAVE = 1 << 18
+
+// vcFrame represents the interrupted context saved by the #VC handler.
+type vcFrame struct {
+	XMM [16][2]uint64
+
+	R15 uint64
+	R14 uint64
+	R13 uint64
+	R12 uint64
+	R11 uint64
+	R10 uint64
+	R9  uint64
+	R8  uint64
+	RDI uint64
+	RSI uint64
+	RBP uint64
+	RDX uint64
+	RCX uint64
+	RBX uint64
+	RAX uint64
+
+	ErrorCode uint64
+
+	RIP    uint64
+	CS     uint64
+	RFLAGS uint64
+	RSP    uint64
+	SS     uint64
+}
+
+// vc represents the #VC handler state, a single GHCB page is shared across
+// all vCPUs and serialized.
+var vc struct {
+	lock uint32
+	ghcb uint64
+}
+
+// defined in vc.s
+func read8(addr uint64) (val uint8)
+func read64(addr uint64) (val uint64)
+func write8(addr uint64, val uint8)
+func write64(addr uint64, val uint64)
+func rdmsr(addr uint64) (val uint64)
+func cr4() (val uint64)
+func xgetbv() (val uint64)
+func sidt() (addr uint64, limit uint16)
+func vcHandlerAddr() (addr uint64)
+
+// EnableVC installs the unikernel VMM Communication Exception (#VC) handler,
+// replacing the firmware one, to service CPUID (through the CPUID Page when
+// available), RDMSR/WRMSR, IN/OUT, MMIO and RDTSC/RDTSCP intercepts through
+// the GHCB protocol.
+//
+// The handler is required for the unikernel to run after exiting EFI boot
+// services. Unsupported intercepts result in a termination request to the
+// hypervisor (see vcTerminate), which kills the VM, this is the case for:
+//
+//   - string I/O (INS, OUTS) and REP prefixed IN/OUT
+//   - IN/OUT and MMIO instructions with segment override (26, 2e, 36, 3e,
+//     64, 65) or address size override (67) prefixes
+//   - MMIO instructions other than MOV and MOVZX, or with register operands
+//   - intercepts other than CPUID, RDMSR/WRMSR, IN/OUT, MMIO and
+//     RDTSC/RDTSCP
+func EnableVC() (err error) {
+	if GHCB == nil {
+		return ErrNotPresent
+	}
+
+	if vc.ghcb == 0 {
+		// dedicated page, never released, for #VC handling
+		addr, buf := dma.Default().Reserve(pageSize, pageSize)
+		clear(buf)
+
+		vc.ghcb = uint64(addr)
+	}
+
+	desc := &amd64.GateDescriptor{
+		SegmentSelector: 1 << 3,
+		Attributes:      amd64.InterruptGate,
+	}
+
+	desc.SetOffset(uintptr(vcHandlerAddr()))
+	gate := desc.Bytes()
+
+	base, _ := sidt()
+	idt := uint(base)
+	r, err := dma.NewRegion(idt, (vectorVC+1)*len(gate), true)
+
+	if err != nil {
+		return
+	}
+
+	r.Write(idt, vectorVC*len(gate), gate)
+
+	return
+}
+
+//go:nosplit
+func (f *vcFrame) reg(n uint64) *uint64 {
+	switch n & 0xf {
+	case 0:
+		return &f.RAX
+	case 1:
+		return &f.RCX
+	case 2:
+		return &f.RDX
+	case 3:
+		return &f.RBX
+	case 4:
+		return &f.RSP
+	case 5:
+		return &f.RBP
+	case 6:
+		return &f.RSI
+	case 7:
+		return &f.RDI
+	case 8:
+		return &f.R8
+	case 9:
+		return &f.R9
+	case 10:
+		return &f.R10
+	case 11:
+		return &f.R11
+	case 12:
+		return &f.R12
+	case 13:
+		return &f.R13
+	case 14:
+		return &f.R14
+	default:
+		return &f.R15
+	}
+}
+
+// get returns a register operand value, legacy high byte registers
+// (AH, CH, DH, BH) are selected for 8-bit operands without REX prefix.
+//
+//go:nosplit
+func (f *vcFrame) get(n uint64, size uint64, rex uint8) uint64 {
+	if size == 1 && rex == 0 && n >= 4 && n < 8 {
+		return (*f.reg(n - 4) >> 8) & 0xff
+	}
+
+	return *f.reg(n) & sizeMask(size)
+}
+
+// set updates a register operand value, 32-bit operands are zero extended.
+//
+//go:nosplit
+func (f *vcFrame) set(n uint64, size uint64, rex uint8, val uint64) {
+	if size == 1 && rex == 0 && n >= 4 && n < 8 {
+		r := f.reg(n - 4)
+		*r = *r&^0xff00 | (val&0xff)<<8
+		return
+	}
+
+	r := f.reg(n)
+
+	switch size {
+	case 4, 8:
+		*r = val & sizeMask(size)
+	default:
+		*r = *r&^sizeMask(size) | val&sizeMask(size)
+	}
+}
+
+//go:nosplit
+func sizeMask(size uint64) uint64 {
+	if size >= 8 {
+		return ^uint64(0)
+	}
+
+	return 1<<(8*size) - 1
+}
+
+// fetch reads a little-endian value of the argument size.
+//
+//go:nosplit
+func fetch(addr uint64, size uint64) (val uint64) {
+	for i := size; i > 0; i-- {
+		val = val<<8 | uint64(read8(addr+i-1))
+	}
+
+	return
+}
+
+//go:nosplit
+func vcSet(off uint64, val uint64) {
+	write64(vc.ghcb+off, val)
+
+	bit := off / 8
+	addr := vc.ghcb + sev.VALID_BITMAP + bit/8
+
+	write8(addr, read8(addr)|1<<(bit%8))
+}
+
+//go:nosplit
+func vcGet(off uint64) uint64 {
+	return read64(vc.ghcb + off)
+}
+
+//go:nosplit
+func vcExit(code uint64, info1 uint64, info2 uint64) bool {
+	vcSet(sev.SW_EXITCODE, code)
+	vcSet(sev.SW_EXITINFO1, info1)
+	vcSet(sev.SW_EXITINFO2, info2)
+
+	vmgexit()
+
+	return uint32(vcGet(sev.SW_EXITINFO1)) == 0
+}
+
+//go:nosplit
+func vcTerminate() {
+	wrmsr(sev.MSR_AMD_GHCB, msrTerminateRequest)
+	vmgexit()
+
+	for {
+	}
+}
+
+// handleVC services a #VC exception, it runs in exception context on the
+// interrupted stack and must therefore never allocate or grow the stack.
+//
+//go:nosplit
+func handleVC(f *vcFrame) {
+	var n uint64
+	var ok bool
+
+	for !atomic.CompareAndSwapUint32(&vc.lock, 0, 1) {
+	}
+
+	prev := rdmsr(sev.MSR_AMD_GHCB)
+
+	if prev != vc.ghcb {
+		if _, ok = registerGPA(vc.ghcb); !ok {
+			vcTerminate()
+		}
+	}
+
+	write64(vc.ghcb+sev.VALID_BITMAP, 0)
+	write64(vc.ghcb+sev.VALID_BITMAP+8, 0)
+
+	switch f.ErrorCode {
+	case exitCPUID:
+		n, ok = vcCPUID(f)
+	case exitMSR:
+		n, ok = vcMSR(f)
+	case exitIOIO:
+		n, ok = vcIOIO(f)
+	case exitNPF:
+		n, ok = vcMMIO(f)
+	case exitRDTSC, exitRDTSCP:
+		n, ok = vcTSC(f)
+	default:
+		ok = false
+	}
+
+	switch {
+	case prev == vc.ghcb || prev == 0:
+	case prev&msrInfoMask != 0:
+		wrmsr(sev.MSR_AMD_GHCB, prev)
+	default:
+		if _, restored := registerGPA(prev); !restored {
+			vcTerminate()
+		}
+	}
+
+	atomic.StoreUint32(&vc.lock, 0)
+
+	if !ok {
+		vcTerminate()
+	}
+
+	f.RIP += n
+}
+
+//go:nosplit
+func vcHypervisorCPUID(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32, ok bool) {
+	xcr0 := uint64(1)
+
+	if cr4()&cr4OSXSAVE != 0 {
+		xcr0 = xgetbv()
+	}
+
+	vcSet(sev.RAX, uint64(leaf))
+	vcSet(ghcbRCX, uint64(subleaf))
+	vcSet(ghcbXCR0, xcr0)
+
+	if !vcExit(exitCPUID, 0, 0) {
+		return
+	}
+
+	eax = uint32(vcGet(sev.RAX))
+	ebx = uint32(vcGet(RBX))
+	ecx = uint32(vcGet(ghcbRCX))
+	edx = uint32(vcGet(ghcbRDX))
+
+	return eax, ebx, ecx, edx, true
+}
+
+// vcCPUID services CPUID, values validated by the firmware in the CPUID Page
+// are preferred, the hypervisor is only trusted with per-vCPU topology and
+// XSAVE area size information.
+//
+//go:nosplit
+func vcCPUID(f *vcFrame) (n uint64, ok bool) {
+	leaf := uint32(f.RAX)
+	subleaf := uint32(f.RCX)
+
+	if cpuidAddr == 0 {
+		eax, ebx, ecx, edx, ok := vcHypervisorCPUID(leaf, subleaf)
+
+		if !ok {
+			return 0, false
+		}
+
+		f.RAX, f.RBX, f.RCX, f.RDX = uint64(eax), uint64(ebx), uint64(ecx), uint64(edx)
+
+		return 2, true
+	}
+
+	// functions not found in the CPUID Page are reported as zero
+	eax, ebx, ecx, edx, _ := cpuidLookup(leaf, subleaf)
+
+	switch {
+	case leaf == 0x1, leaf == 0xb, leaf == 0xd && subleaf <= 1, leaf == 0x8000001e:
+		hvEAX, hvEBX, hvECX, hvEDX, ok := vcHypervisorCPUID(leaf, subleaf)
+
+		if !ok {
+			return 0, false
+		}
+
+		switch leaf {
+		case 0x1:
+			// initial APIC ID
+			ebx = ebx&0x00ffffff | hvEBX&0xff000000
+
+			// OSXSAVE
+			if ecx &^= 1 << 27; cr4()&cr4OSXSAVE != 0 {
+				ecx |= 1 << 27
+			}
+		case 0xb:
+			// x2APIC ID
+			edx = hvEDX
+		case 0xd:
+			// XSAVE area size for enabled features
+			ebx = hvEBX
+		case 0x8000001e:
+			// extended APIC, core and node IDs
+			eax = hvEAX
+			ebx = ebx&^0xff | hvEBX&0xff
+			ecx = ecx&^0xff | hvECX&0xff
+		}
+	}
+
+	f.RAX, f.RBX, f.RCX, f.RDX = uint64(eax), uint64(ebx), uint64(ecx), uint64(edx)
+
+	return 2, true
+}
+
+// vcMSR services RDMSR (0f 32) and WRMSR (0f 30).
+//
+//go:nosplit
+func vcMSR(f *vcFrame) (n uint64, ok bool) {
+	var write uint64
+
+	if read8(f.RIP) != 0x0f {
+		return
+	}
+
+	switch read8(f.RIP + 1) {
+	case 0x30:
+		write = 1
+		vcSet(sev.RAX, f.RAX&0xffffffff)
+		vcSet(ghcbRDX, f.RDX&0xffffffff)
+	case 0x32:
+	default:
+		return
+	}
+
+	vcSet(ghcbRCX, f.RCX)
+
+	if !vcExit(exitMSR, write, 0) {
+		return
+	}
+
+	if write == 0 {
+		f.RAX = vcGet(sev.RAX) & 0xffffffff
+		f.RDX = vcGet(ghcbRDX) & 0xffffffff
+	}
+
+	return 2, true
+}
+
+// vcTSC services RDTSC (0f 31) and RDTSCP (0f 01 f9).
+//
+//go:nosplit
+func vcTSC(f *vcFrame) (n uint64, ok bool) {
+	n = 2
+
+	if f.ErrorCode == exitRDTSCP {
+		n = 3
+	}
+
+	if !vcExit(f.ErrorCode, 0, 0) {
+		return 0, false
+	}
+
+	f.RAX = vcGet(sev.RAX) & 0xffffffff
+	f.RDX = vcGet(ghcbRDX) & 0xffffffff
+
+	if f.ErrorCode == exitRDTSCP {
+		f.RCX = vcGet(ghcbRCX) & 0xffffffff
+	}
+
+	return n, true
+}
+
+// vcIOIO services IN and OUT, string instructions are not supported.
+//
+//go:nosplit
+func vcIOIO(f *vcFrame) (n uint64, ok bool) {
+	var port uint64
+	var info uint64
+
+	size := uint64(4)
+
+	// operand size override prefix
+	for read8(f.RIP+n) == 0x66 {
+		size = 2
+		n++
+	}
+
+	op := read8(f.RIP + n)
+	n++
+
+	switch op {
+	case 0xe4, 0xe5, 0xe6, 0xe7:
+		port = uint64(read8(f.RIP + n))
+		n++
+	case 0xec, 0xed, 0xee, 0xef:
+		port = f.RDX & 0xffff
+	default:
+		return 0, false
+	}
+
+	if op&1 == 0 {
+		size = 1
+	}
+
+	switch size {
+	case 1:
+		info |= ioioSize8
+	case 2:
+		info |= ioioSize16
+	case 4:
+		info |= ioioSize32
+	}
+
+	in := op == 0xe4 || op == 0xe5 || op == 0xec || op == 0xed
+	info |= port<<16 | ioioAddr64
+
+	if in {
+		info |= ioioTypeIn
+	} else {
+		vcSet(sev.RAX, f.RAX&sizeMask(size))
+	}
+
+	if !vcExit(exitIOIO, info, 0) {
+		return 0, false
+	}
+
+	if in {
+		f.set(0, size, 0, vcGet(sev.RAX))
+	}
+
+	return n, true
+}
+
+// vcMMIO services MMIO accesses performed with MOV (88, 89, 8a, 8b, c6, c7)
+// and MOVZX (0f b6, 0f b7), as identity mapped the effective address is used
+// as guest physical address.
+//
+//go:nosplit
+func vcMMIO(f *vcFrame) (n uint64, ok bool) {
+	var rex uint8
+	var addr uint64
+	var write, imm, relative bool
+
+	rip := f.RIP
+	size := uint64(4)
+	src := uint64(0)
+
+	for {
+		b := read8(rip + n)
+
+		if b == 0x66 {
+			size = 2
+		} else if b&0xf0 == 0x40 {
+			rex = b
+		} else {
+			break
+		}
+
+		n++
+	}
+
+	if rex&0x8 != 0 {
+		size = 8
+	}
+
+	op := read8(rip + n)
+	n++
+
+	switch op {
+	case 0x88:
+		write, size = true, 1
+	case 0x89:
+		write = true
+	case 0x8a:
+		size = 1
+	case 0x8b:
+	case 0xc6:
+		write, imm, size = true, true, 1
+	case 0xc7:
+		write, imm = true, true
+	case 0x0f:
+		switch read8(rip + n) {
+		case 0xb6:
+			src = 1
+		case 0xb7:
+			src = 2
+		default:
+			return 0, false
+		}
+		n++
+	default:
+		return 0, false
+	}
+
+	modrm := read8(rip + n)
+	n++
+
+	mod := modrm >> 6
+	reg := uint64(modrm>>3&7) | uint64(rex>>2&1)<<3
+	rm := uint64(modrm & 7)
+
+	switch {
+	case mod == 3:
+		return 0, false
+	case rm == 4:
+		sib := read8(rip + n)
+		n++
+
+		index := uint64(sib>>3&7) | uint64(rex>>1&1)<<3
+		base := uint64(sib&7) | uint64(rex&1)<<3
+
+		if index != 4 {
+			addr += *f.reg(index) << (sib >> 6)
+		}
+
+		if sib&7 == 5 && mod == 0 {
+			addr += uint64(int64(int32(fetch(rip+n, 4))))
+			n += 4
+		} else {
+			addr += *f.reg(base)
+		}
+	case rm == 5 && mod == 0:
+		relative = true
+		addr += uint64(int64(int32(fetch(rip+n, 4))))
+		n += 4
+	default:
+		addr += *f.reg(rm | uint64(rex&1)<<3)
+	}
+
+	switch mod {
+	case 1:
+		addr += uint64(int64(int8(read8(rip + n))))
+		n++
+	case 2:
+		addr += uint64(int64(int32(fetch(rip+n, 4))))
+		n += 4
+	}
+
+	var val uint64
+	var immSize uint64
+
+	if imm {
+		if immSize = size; immSize == 8 {
+			immSize = 4
+		}
+
+		val = fetch(rip+n, immSize)
+
+		if size == 8 {
+			val = uint64(int64(int32(val)))
+		}
+
+		n += immSize
+	}
+
+	if relative {
+		addr += rip + n
+	}
+
+	buf := vc.ghcb + sev.SharedBuffer
+	vcSet(sev.SW_SCRATCH, buf)
+
+	if write {
+		if !imm {
+			val = f.get(reg, size, rex)
+		}
+
+		write64(buf, val)
+
+		if !vcExit(exitMMIOWrite, addr, size) {
+			return 0, false
+		}
+
+		return n, true
+	}
+
+	access := size
+
+	if src != 0 {
+		access = src
+	}
+
+	if !vcExit(exitMMIORead, addr, access) {
+		return 0, false
+	}
+
+	f.set(reg, size, rex, read64(buf)&sizeMask(access))
+
+	return n, true
+}
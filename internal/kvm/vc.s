@@ -0,0 +1,163 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func read8(addr uint64) (val uint8)
+TEXT ·read8(SB),NOSPLIT,$0-9
+	MOVQ	addr+0(FP), AX
+	MOVB	(AX), BX
+	MOVB	BX, val+8(FP)
+	RET
+
+// func read64(addr uint64) (val uint64)
+TEXT ·read64(SB),NOSPLIT,$0-16
+	MOVQ	addr+0(FP), AX
+	MOVQ	(AX), BX
+	MOVQ	BX, val+8(FP)
+	RET
+
+// func write8(addr uint64, val uint8)
+TEXT ·write8(SB),NOSPLIT,$0-9
+	MOVQ	addr+0(FP), AX
+	MOVB	val+8(FP), BX
+	MOVB	BX, (AX)
+	RET
+
+// func write64(addr uint64, val uint64)
+TEXT ·write64(SB),NOSPLIT,$0-16
+	MOVQ	addr+0(FP), AX
+	MOVQ	val+8(FP), BX
+	MOVQ	BX, (AX)
+	RET
+
+// func rdmsr(addr uint64) (val uint64)
+TEXT ·rdmsr(SB),NOSPLIT,$0-16
+	MOVQ	addr+0(FP), CX
+	RDMSR
+	SHLQ	$32, DX
+	ORQ	DX, AX
+	MOVQ	AX, val+8(FP)
+	RET
+
+// func cr4() (val uint64)
+TEXT ·cr4(SB),NOSPLIT,$0-8
+	MOVQ	CR4, AX
+	MOVQ	AX, val+0(FP)
+	RET
+
+// func xgetbv() (val uint64)
+TEXT ·xgetbv(SB),NOSPLIT,$0-8
+	MOVL	$0, CX
+	// xgetbv
+	BYTE	$0x0f
+	BYTE	$0x01
+	BYTE	$0xd0
+	SHLQ	$32, DX
+	ORQ	DX, AX
+	MOVQ	AX, val+0(FP)
+	RET
+
+// func sidt() (addr uint64, limit uint16)
+TEXT ·sidt(SB),NOSPLIT,$0-10
+	SUBQ	$16, SP
+	SIDT	(SP)
+	MOVW	0(SP), BX
+	MOVQ	2(SP), AX
+	ADDQ	$16, SP
+	MOVQ	AX, addr+0(FP)
+	MOVW	BX, limit+8(FP)
+	RET
+
+// func vcHandlerAddr() (addr uint64)
+TEXT ·vcHandlerAddr(SB),NOSPLIT,$0-8
+	MOVQ	$·vcHandler(SB), AX
+	MOVQ	AX, addr+0(FP)
+	RET
+
+// The #VC handler saves the interrupted context, in vcFrame format, to invoke
+// handleVC on the current stack.
+TEXT ·vcHandler(SB),NOSPLIT|NOFRAME,$0
+	// save general purpose registers
+	PUSHQ	AX
+	PUSHQ	BX
+	PUSHQ	CX
+	PUSHQ	DX
+	PUSHQ	BP
+	PUSHQ	SI
+	PUSHQ	DI
+	PUSHQ	R8
+	PUSHQ	R9
+	PUSHQ	R10
+	PUSHQ	R11
+	PUSHQ	R12
+	PUSHQ	R13
+	PUSHQ	R14
+	PUSHQ	R15
+
+	// save SSE registers, X15 is clobbered by Go ABIInternal
+	SUBQ	$256, SP
+	MOVUPS	X0, 0(SP)
+	MOVUPS	X1, 16(SP)
+	MOVUPS	X2, 32(SP)
+	MOVUPS	X3, 48(SP)
+	MOVUPS	X4, 64(SP)
+	MOVUPS	X5, 80(SP)
+	MOVUPS	X6, 96(SP)
+	MOVUPS	X7, 112(SP)
+	MOVUPS	X8, 128(SP)
+	MOVUPS	X9, 144(SP)
+	MOVUPS	X10, 160(SP)
+	MOVUPS	X11, 176(SP)
+	MOVUPS	X12, 192(SP)
+	MOVUPS	X13, 208(SP)
+	MOVUPS	X14, 224(SP)
+	MOVUPS	X15, 240(SP)
+
+	MOVQ	SP, AX
+	SUBQ	$8, SP
+	MOVQ	AX, 0(SP)
+	CALL	·handleVC(SB)
+	ADDQ	$8, SP
+
+	// restore SSE registers
+	MOVUPS	0(SP), X0
+	MOVUPS	16(SP), X1
+	MOVUPS	32(SP), X2
+	MOVUPS	48(SP), X3
+	MOVUPS	64(SP), X4
+	MOVUPS	80(SP), X5
+	MOVUPS	96(SP), X6
+	MOVUPS	112(SP), X7
+	MOVUPS	128(SP), X8
+	MOVUPS	144(SP), X9
+	MOVUPS	160(SP), X10
+	MOVUPS	176(SP), X11
+	MOVUPS	192(SP), X12
+	MOVUPS	208(SP), X13
+	MOVUPS	224(SP), X14
+	MOVUPS	240(SP), X15
+	ADDQ	$256, SP
+
+	// restore general purpose registers
+	POPQ	R15
+	POPQ	R14
+	POPQ	R13
+	POPQ	R12
+	POPQ	R11
+	POPQ	R10
+	POPQ	R9
+	POPQ	R8
+	POPQ	DI
+	POPQ	SI
+	POPQ	BP
+	POPQ	DX
+	POPQ	CX
+	POPQ	BX
+	POPQ	AX
+
+	// discard error code
+	ADDQ	$8, SP
+	IRETQ
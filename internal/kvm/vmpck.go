@@ -0,0 +1,267 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/usbarmory/tamago/kvm/sev"
+
+	"github.com/usbarmory/tamago-sev-example/internal/bounce"
+	"github.com/usbarmory/tamago-sev-example/internal/guestmsg"
+)
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// Table 7: List of Supported Non-Automatic Events.
+const SNP_EXT_GUEST_REQUEST = 0x80000012
+
+// SEV-ES Guest-Hypervisor Communication Block Standardization
+// 4.1.8 SNP Extended Guest Request.
+const (
+	vmmErrInvalidLen = 1
+
+	// certificate buffer size (in pages), the VCEK/VLEK, ASK and ARK
+	// certificates require less than 8KB
+	certPages = 4
+)
+
+// SEV Secure Nested Paging Firmware ABI Specification
+// Table 71: Secrets Page Format.
+const (
+	vmpckOffset = 0x20
+	vmpckSize   = 32
+)
+
+var (
+	// ErrNotPresent is returned when guest requests are not available.
+	ErrNotPresent = errors.New("GHCB not present")
+
+	// ErrNoVMPCK is returned when all VMPCKs have been invalidated.
+	ErrNoVMPCK = guestmsg.ErrNoVMPCK
+)
+
+// VMPCKState represents the state of a VM Communication Key.
+type VMPCKState = guestmsg.State
+
+// vmpcks represents the guest message manager, which owns VMPCK selection and
+// sequence numbers for all vCPUs.
+var vmpcks struct {
+	sync.Mutex
+	*guestmsg.Keys
+}
+
+// secretsAddr is the Secrets Page physical address.
+var secretsAddr uint
+
+func initVMPCK() {
+	if vmpcks.Keys != nil {
+		return
+	}
+
+	preferred := 0
+
+	// Under an SVSM, keys for lower VMPLs are not available and the one
+	// matching the current VMPL is preferred.
+	if vmpl := VMPL(); vmpl < guestmsg.NumVMPCK {
+		preferred = vmpl
+	}
+
+	vmpcks.Keys, _ = guestmsg.New([][]byte{
+		Secrets.VMPCK0[:],
+		Secrets.VMPCK1[:],
+		Secrets.VMPCK2[:],
+		Secrets.VMPCK3[:],
+	}, preferred)
+
+	// an invalidated key is wiped from the Secrets Page as well
+	vmpcks.Wipe = func(index int) {
+		if secretsAddr != 0 {
+			scrub(secretsAddr+uint(vmpckOffset+index*vmpckSize), vmpckSize)
+		}
+	}
+}
+
+// VMPCK returns the state of all VM Communication Keys.
+func VMPCK() (state []VMPCKState) {
+	vmpcks.Lock()
+	defer vmpcks.Unlock()
+
+	if Secrets == nil {
+		return
+	}
+
+	initVMPCK()
+
+	return vmpcks.State()
+}
+
+// SetVMPCK sets the preferred VM Communication Key for guest requests, other
+// keys are only used as fallback on invalidation.
+func SetVMPCK(index int) (err error) {
+	vmpcks.Lock()
+	defer vmpcks.Unlock()
+
+	if Secrets == nil {
+		return errors.New("secrets not available")
+	}
+
+	initVMPCK()
+
+	return vmpcks.SetPreferred(index)
+}
+
+// ghcbTransport implements [guestmsg.Transport] through a GHCB.
+type ghcbTransport struct {
+	b *sev.GHCB
+}
+
+// Seal implements [guestmsg.Transport.Seal].
+func (t ghcbTransport) Seal(k *guestmsg.Key, messageType int, req []byte) (msg []byte, err error) {
+	hdr := &sev.MessageHeader{
+		Algo:           sev.AES_256_GCM,
+		HeaderVersion:  headerVersion,
+		HeaderSize:     headerSize,
+		MessageType:    uint8(messageType),
+		MessageVersion: messageVersion,
+		VMPCK:          uint8(k.Index),
+	}
+
+	return sealMessage(hdr, req, k.Secret, k.Seq)
+}
+
+// Send implements [guestmsg.Transport.Send].
+func (t ghcbTransport) Send(k *guestmsg.Key, msg []byte, ext bool) (res []byte, certs []byte, err error) {
+	return transmit(t.b, k, msg, ext)
+}
+
+// guestRequest issues an SNP Guest Request, or an SNP Extended Guest Request
+// returning the hypervisor certificate table when ext is true, through the
+// argument GHCB (see [guestmsg.Keys.Request]).
+func guestRequest(b *sev.GHCB, messageType int, req []byte, ext bool) (res []byte, certs []byte, err error) {
+	vmpcks.Lock()
+	defer vmpcks.Unlock()
+
+	initVMPCK()
+
+	return vmpcks.Request(ghcbTransport{b}, messageType, req, ext)
+}
+
+// transmit sends a sealed guest message and returns its decrypted response.
+//
+// The request, response and certificate pages are mapped through bounce
+// buffers (see [Bounce]), so that they are held in shared memory only for the
+// duration of the request.
+func transmit(b *sev.GHCB, k *guestmsg.Key, msg []byte, ext bool) (res []byte, certs []byte, err error) {
+	var required uint64
+
+	pool, err := Bounce()
+
+	if err != nil {
+		return
+	}
+
+	c := pool.Client("guest-request", (2+certPages)*pageSize)
+
+	req := make([]byte, pageSize)
+	copy(req, msg)
+
+	reqBuf, err := c.Map(req, pageSize, bounce.ToDevice)
+
+	if err != nil {
+		return
+	}
+
+	defer reqBuf.Unmap()
+
+	buf := make([]byte, pageSize)
+	resBuf, err := c.Map(buf, pageSize, bounce.FromDevice)
+
+	if err != nil {
+		return
+	}
+
+	defer resBuf.Unmap()
+
+	fields := map[uint]uint64{
+		sev.SW_EXITINFO1: uint64(reqBuf.Addr),
+		sev.SW_EXITINFO2: uint64(resBuf.Addr),
+	}
+
+	code := uint64(sev.SNP_GUEST_REQUEST)
+
+	if ext {
+		var certsBuf *bounce.Buffer
+
+		certs = make([]byte, certPages*pageSize)
+
+		if certsBuf, err = c.Map(certs, pageSize, bounce.FromDevice); err != nil {
+			return nil, nil, err
+		}
+
+		defer certsBuf.Unmap()
+
+		code = SNP_EXT_GUEST_REQUEST
+		fields[sev.RAX] = uint64(certsBuf.Addr)
+		fields[RBX] = certPages
+
+		defer func() {
+			if err == nil {
+				certsBuf.Sync()
+			} else {
+				certs = nil
+			}
+		}()
+	}
+
+	// yield to hypervisor
+	info1, info2, err := ghcbExit(b, code, fields)
+
+	if err != nil {
+		return
+	}
+
+	if ext && (info2>>32) == vmmErrInvalidLen {
+		required = ghcbRead(b, RBX)
+
+		// The request has not been forwarded to the firmware, as
+		// its sealed message must not be reused (with a different
+		// sequence number) it is re-issued as a plain guest request.
+		if info1, info2, err = ghcbExit(b, sev.SNP_GUEST_REQUEST, map[uint]uint64{
+			sev.SW_EXITINFO1: uint64(reqBuf.Addr),
+			sev.SW_EXITINFO2: uint64(resBuf.Addr),
+		}); err != nil {
+			return
+		}
+	}
+
+	if info1 != 0 || (info2>>32) != 0 {
+		return nil, nil, fmt.Errorf("exit error (info1:%#x info2:%#x)", info1, info2)
+	}
+
+	// the firmware consumed the request sequence number
+	k.Seq += 1
+
+	if fwErr := uint32(info2); fwErr != 0 {
+		return nil, nil, fmt.Errorf("%w, firmware error %#x", guestmsg.ErrFirmware, fwErr)
+	}
+
+	// copy response buffer as soon as possible as GHCB might overwrite it
+	resBuf.Sync()
+
+	if res, err = openMessage(buf, k.Secret, k.Seq); err != nil {
+		return nil, nil, fmt.Errorf("%w, %v", guestmsg.ErrFirmware, err)
+	}
+
+	k.Seq += 1
+
+	if required > 0 {
+		return nil, nil, fmt.Errorf("%w (%d pages required)", guestmsg.ErrCertsLength, required)
+	}
+
+	return
+}
@@ -0,0 +1,101 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package kvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/usbarmory/tamago-sev-example/internal/svsm"
+)
+
+// Secure VM Service Module for SEV-SNP Guests
+// 8.2 svsm.SVSM_VTPM_CMD Call (TPM_SEND_COMMAND platform command).
+const (
+	tpmSendCommand = 8
+
+	// command, locality, command size
+	vtpmRequestHeader = 4 + 1 + 4
+	// response size
+	vtpmResponseHeader = 4
+)
+
+// VTPM represents an SVSM vTPM instance, usable as TPM command transport
+// (see tpm.Transport).
+type VTPM struct {
+	sync.Mutex
+
+	// Locality is the TPM locality used for commands.
+	Locality uint8
+
+	buf  []byte
+	addr uint64
+}
+
+// OpenVTPM returns the SVSM vTPM, an error is returned when the unikernel is
+// not running under an SVSM or its vTPM does not support TPM commands.
+func OpenVTPM() (t *VTPM, err error) {
+	if SVSMInfo() == nil {
+		return nil, errors.New("SVSM not present")
+	}
+
+	if _, maxVersion, err := SVSMQueryProtocol(svsm.SVSM_VTPM_PROTOCOL, 1); err != nil || maxVersion == 0 {
+		return nil, errors.New("SVSM vTPM protocol not supported")
+	}
+
+	commands, _, _, err := SVSMCall(svsm.SVSM_VTPM_PROTOCOL, svsm.SVSM_VTPM_QUERY, 0, 0, 0, 0)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not query vTPM, %v", err)
+	}
+
+	if commands&(1<<tpmSendCommand) == 0 {
+		return nil, errors.New("vTPM does not support TPM_SEND_COMMAND")
+	}
+
+	t = &VTPM{}
+	t.buf, t.addr = alloc(pageSize, pageSize)
+
+	return
+}
+
+// Send transmits a TPM command to the vTPM and returns its response.
+func (t *VTPM) Send(cmd []byte) (rsp []byte, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(cmd) > len(t.buf)-vtpmRequestHeader {
+		return nil, fmt.Errorf("invalid command size (%d)", len(cmd))
+	}
+
+	clear(t.buf)
+
+	binary.LittleEndian.PutUint32(t.buf[0:], tpmSendCommand)
+	t.buf[4] = t.Locality
+	binary.LittleEndian.PutUint32(t.buf[5:], uint32(len(cmd)))
+	copy(t.buf[vtpmRequestHeader:], cmd)
+
+	_, _, _, err = SVSMCall(svsm.SVSM_VTPM_PROTOCOL, svsm.SVSM_VTPM_CMD, t.addr, 0, 0, 0)
+	runtime.KeepAlive(t.buf)
+
+	if err != nil {
+		return
+	}
+
+	size := int(int32(binary.LittleEndian.Uint32(t.buf[0:])))
+
+	if size < 0 || size > len(t.buf)-vtpmResponseHeader {
+		return nil, fmt.Errorf("invalid response size (%d)", size)
+	}
+
+	rsp = make([]byte, size)
+	copy(rsp, t.buf[vtpmResponseHeader:])
+
+	return
+}
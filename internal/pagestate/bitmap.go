@@ -0,0 +1,202 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package pagestate implements a model of guest physical memory page state,
+// tracking private (encrypted and validated) and shared (unencrypted) pages
+// along with the granularity (4K or 2M) of their RMP assignment.
+package pagestate
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// PageSize is the tracking granularity.
+	PageSize = 4096
+	// LargePageSize is the 2M page size.
+	LargePageSize = 2 << 20
+
+	pagesPerLarge = LargePageSize / PageSize
+)
+
+// Range represents a contiguous range of pages sharing the same state.
+type Range struct {
+	// Start is the range start address.
+	Start uint64
+	// End is the range end address (exclusive).
+	End uint64
+	// Shared is the range state.
+	Shared bool
+	// Large is whether the range is assigned with 2M pages.
+	Large bool
+}
+
+// Pages returns the number of 4K pages in the range.
+func (r Range) Pages() int {
+	return int((r.End - r.Start) / PageSize)
+}
+
+// Bitmap represents the state of a physical memory range, all pages are
+// initially private.
+type Bitmap struct {
+	start  uint64
+	pages  int
+	shared []uint64
+	large  []uint64
+}
+
+// New returns a bitmap tracking the argument physical memory range.
+func New(start uint64, end uint64) (b *Bitmap, err error) {
+	if start%PageSize != 0 || end%PageSize != 0 || end <= start {
+		return nil, fmt.Errorf("invalid range (%#x-%#x)", start, end)
+	}
+
+	n := int((end - start) / PageSize)
+
+	return &Bitmap{
+		start:  start,
+		pages:  n,
+		shared: make([]uint64, (n+63)/64),
+		large:  make([]uint64, (n+63)/64),
+	}, nil
+}
+
+// Start returns the tracked range start address.
+func (b *Bitmap) Start() uint64 {
+	return b.start
+}
+
+// End returns the tracked range end address.
+func (b *Bitmap) End() uint64 {
+	return b.start + uint64(b.pages)*PageSize
+}
+
+func get(bits []uint64, i int) bool {
+	return bits[i/64]&(1<<(i%64)) != 0
+}
+
+func set(bits []uint64, i int, val bool) {
+	if val {
+		bits[i/64] |= 1 << (i % 64)
+	} else {
+		bits[i/64] &^= 1 << (i % 64)
+	}
+}
+
+// index returns the page indices for the argument range.
+func (b *Bitmap) index(start uint64, end uint64) (first int, last int, err error) {
+	if start%PageSize != 0 || end%PageSize != 0 || end <= start {
+		return 0, 0, fmt.Errorf("invalid range (%#x-%#x)", start, end)
+	}
+
+	if start < b.Start() || end > b.End() {
+		return 0, 0, fmt.Errorf("range (%#x-%#x) outside tracked memory", start, end)
+	}
+
+	return int((start - b.start) / PageSize), int((end - b.start) / PageSize), nil
+}
+
+// Set updates the state of the argument range, the large flag indicates an
+// assignment with 2M pages which requires 2M alignment.
+func (b *Bitmap) Set(start uint64, end uint64, shared bool, large bool) (err error) {
+	if large && (start%LargePageSize != 0 || end%LargePageSize != 0) {
+		return errors.New("range not 2M aligned")
+	}
+
+	first, last, err := b.index(start, end)
+
+	if err != nil {
+		return
+	}
+
+	for i := first; i < last; i++ {
+		set(b.shared, i, shared)
+		set(b.large, i, large)
+	}
+
+	if large {
+		return
+	}
+
+	// A 4K update over part of a 2M page splits it, therefore the whole
+	// 2M page is no longer large.
+	lo := max(start&^(LargePageSize-1), b.Start())
+	hi := min((end+LargePageSize-1)&^(LargePageSize-1), b.End())
+
+	for i := int((lo - b.start) / PageSize); i < int((hi-b.start)/PageSize); i++ {
+		set(b.large, i, false)
+	}
+
+	return
+}
+
+// Shared returns whether the page holding the argument address is shared,
+// addresses outside the tracked range are reported as private.
+func (b *Bitmap) Shared(addr uint64) bool {
+	if addr < b.Start() || addr >= b.End() {
+		return false
+	}
+
+	return get(b.shared, int((addr-b.start)/PageSize))
+}
+
+// Uniform returns whether all pages in the argument range are in the argument
+// state.
+func (b *Bitmap) Uniform(start uint64, end uint64, shared bool) (ok bool, err error) {
+	first, last, err := b.index(start, end)
+
+	if err != nil {
+		return
+	}
+
+	for i := first; i < last; i++ {
+		if get(b.shared, i) != shared {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Count returns the number of private and shared 4K pages, as well as the
+// number of 2M pages.
+func (b *Bitmap) Count() (private int, shared int, large int) {
+	var n int
+
+	for i := 0; i < b.pages; i++ {
+		if get(b.shared, i) {
+			shared++
+		} else {
+			private++
+		}
+
+		if get(b.large, i) {
+			n++
+		}
+	}
+
+	return private, shared, n / pagesPerLarge
+}
+
+// Ranges returns all contiguous ranges of pages sharing the same state.
+func (b *Bitmap) Ranges() (ranges []Range) {
+	for i := 0; i < b.pages; {
+		r := Range{
+			Start:  b.start + uint64(i)*PageSize,
+			Shared: get(b.shared, i),
+			Large:  get(b.large, i),
+		}
+
+		for i < b.pages && get(b.shared, i) == r.Shared && get(b.large, i) == r.Large {
+			i++
+		}
+
+		r.End = b.start + uint64(i)*PageSize
+		ranges = append(ranges, r)
+	}
+
+	return
+}
@@ -0,0 +1,280 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package pagestate
+
+import (
+	"slices"
+	"testing"
+)
+
+const (
+	testStart = 0x40000000
+	testEnd   = testStart + 2*LargePageSize
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		start uint64
+		end   uint64
+		valid bool
+	}{
+		{testStart, testEnd, true},
+		{0, PageSize, true},
+		{testStart + 1, testEnd, false},
+		{testStart, testEnd - 1, false},
+		{testStart, testStart, false},
+		{testEnd, testStart, false},
+	} {
+		b, err := New(tt.start, tt.end)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%#x-%#x: got %v, want valid %v", tt.start, tt.end, err, tt.valid)
+			continue
+		}
+
+		if tt.valid && (b.Start() != tt.start || b.End() != tt.end) {
+			t.Errorf("%#x-%#x: got %#x-%#x", tt.start, tt.end, b.Start(), b.End())
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		start  uint64
+		end    uint64
+		large  bool
+		valid  bool
+		shared int
+	}{
+		{"single page", testStart, testStart + PageSize, false, true, 1},
+		{"last page", testEnd - PageSize, testEnd, false, true, 1},
+		{"whole range", testStart, testEnd, false, true, 2 * pagesPerLarge},
+		{"large page", testStart, testStart + LargePageSize, true, true, pagesPerLarge},
+		{"large unaligned", testStart + PageSize, testStart + LargePageSize + PageSize, true, false, 0},
+		{"unaligned start", testStart + 1, testStart + PageSize, false, false, 0},
+		{"unaligned end", testStart, testStart + PageSize + 1, false, false, 0},
+		{"empty", testStart, testStart, false, false, 0},
+		{"below", testStart - PageSize, testStart + PageSize, false, false, 0},
+		{"above", testEnd - PageSize, testEnd + PageSize, false, false, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New(testStart, testEnd)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = b.Set(tt.start, tt.end, true, tt.large)
+
+			if (err == nil) != tt.valid {
+				t.Fatalf("got %v, want valid %v", err, tt.valid)
+			}
+
+			if _, shared, _ := b.Count(); shared != tt.shared {
+				t.Errorf("got %d shared pages, want %d", shared, tt.shared)
+			}
+
+			if tt.valid && (!b.Shared(tt.start) || !b.Shared(tt.end-1)) {
+				t.Error("range not shared")
+			}
+		})
+	}
+}
+
+func TestShared(t *testing.T) {
+	b, err := New(testStart, testEnd)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.Set(testStart, testEnd, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		addr   uint64
+		shared bool
+	}{
+		{testStart, true},
+		{testStart + PageSize - 1, true},
+		{testEnd - 1, true},
+		{testStart - 1, false},
+		{testEnd, false},
+	} {
+		if got := b.Shared(tt.addr); got != tt.shared {
+			t.Errorf("%#x: got %v, want %v", tt.addr, got, tt.shared)
+		}
+	}
+}
+
+func TestUniform(t *testing.T) {
+	b, err := New(testStart, testEnd)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// share the second and third pages
+	if err = b.Set(testStart+PageSize, testStart+3*PageSize, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		start  uint64
+		end    uint64
+		shared bool
+		ok     bool
+		valid  bool
+	}{
+		{"private", testStart, testStart + PageSize, false, true, true},
+		{"shared", testStart + PageSize, testStart + 3*PageSize, true, true, true},
+		{"not shared", testStart + PageSize, testStart + 3*PageSize, false, false, true},
+		{"mixed shared", testStart, testStart + 2*PageSize, true, false, true},
+		{"mixed private", testStart, testStart + 2*PageSize, false, false, true},
+		{"tail private", testStart + 3*PageSize, testEnd, false, true, true},
+		{"unaligned", testStart + 1, testStart + PageSize, false, false, false},
+		{"outside", testEnd, testEnd + PageSize, false, false, false},
+	} {
+		ok, err := b.Uniform(tt.start, tt.end, tt.shared)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+
+		if ok != tt.ok {
+			t.Errorf("%s: got %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		set     []Range
+		private int
+		shared  int
+		large   int
+	}{
+		{"initial", nil, 2 * pagesPerLarge, 0, 0},
+		{"shared page", []Range{
+			{testStart, testStart + PageSize, true, false},
+		}, 2*pagesPerLarge - 1, 1, 0},
+		{"private large", []Range{
+			{testStart, testStart + LargePageSize, false, true},
+		}, 2 * pagesPerLarge, 0, 1},
+		{"shared large", []Range{
+			{testStart, testEnd, true, true},
+		}, 0, 2 * pagesPerLarge, 2},
+		{"reverted", []Range{
+			{testStart, testEnd, true, true},
+			{testStart, testStart + LargePageSize, false, false},
+		}, pagesPerLarge, pagesPerLarge, 1},
+		{"split", []Range{
+			{testStart, testEnd, false, true},
+			{testStart + PageSize, testStart + 2*PageSize, true, false},
+		}, 2*pagesPerLarge - 1, 1, 1},
+		{"split tail", []Range{
+			{testStart, testEnd, true, true},
+			{testEnd - PageSize, testEnd, false, false},
+		}, 1, 2*pagesPerLarge - 1, 1},
+	} {
+		b, err := New(testStart, testEnd)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, r := range tt.set {
+			if err = b.Set(r.Start, r.End, r.Shared, r.Large); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		private, shared, large := b.Count()
+
+		if private != tt.private || shared != tt.shared || large != tt.large {
+			t.Errorf("%s: got %d/%d/%d, want %d/%d/%d", tt.name, private, shared, large, tt.private, tt.shared, tt.large)
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		set  []Range
+		want []Range
+	}{
+		{"initial", nil, []Range{
+			{testStart, testEnd, false, false},
+		}},
+		{"shared head", []Range{
+			{testStart, testStart + PageSize, true, false},
+		}, []Range{
+			{testStart, testStart + PageSize, true, false},
+			{testStart + PageSize, testEnd, false, false},
+		}},
+		{"shared middle", []Range{
+			{testStart + PageSize, testStart + 2*PageSize, true, false},
+		}, []Range{
+			{testStart, testStart + PageSize, false, false},
+			{testStart + PageSize, testStart + 2*PageSize, true, false},
+			{testStart + 2*PageSize, testEnd, false, false},
+		}},
+		{"large tail", []Range{
+			{testStart + LargePageSize, testEnd, false, true},
+		}, []Range{
+			{testStart, testStart + LargePageSize, false, false},
+			{testStart + LargePageSize, testEnd, false, true},
+		}},
+		{"split", []Range{
+			{testStart, testEnd, false, true},
+			{testStart + PageSize, testStart + 2*PageSize, true, false},
+		}, []Range{
+			{testStart, testStart + PageSize, false, false},
+			{testStart + PageSize, testStart + 2*PageSize, true, false},
+			{testStart + 2*PageSize, testStart + LargePageSize, false, false},
+			{testStart + LargePageSize, testEnd, false, true},
+		}},
+		{"merged", []Range{
+			{testStart, testStart + PageSize, true, false},
+			{testStart + PageSize, testEnd, true, false},
+		}, []Range{
+			{testStart, testEnd, true, false},
+		}},
+	} {
+		b, err := New(testStart, testEnd)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, r := range tt.set {
+			if err = b.Set(r.Start, r.End, r.Shared, r.Large); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := b.Ranges()
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+
+		var pages int
+
+		for _, r := range got {
+			pages += r.Pages()
+		}
+
+		if pages != 2*pagesPerLarge {
+			t.Errorf("%s: ranges cover %d pages", tt.name, pages)
+		}
+	}
+}
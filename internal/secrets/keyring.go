@@ -0,0 +1,174 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package secrets implements an in-memory keyring, provisioned at runtime by
+// a remote key broker upon successful attestation.
+package secrets
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/usbarmory/tamago-sev-example/attest"
+	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+	"github.com/usbarmory/tamago-sev-example/internal/teardown"
+)
+
+// maxResponseSize is the maximum key broker response size.
+const maxResponseSize = 64 * 1024
+
+// BrokerKey is the key broker Ed25519 identity public key (hex), required to
+// authenticate responses, set at link time.
+var BrokerKey string
+
+var keyring = struct {
+	sync.RWMutex
+	secrets map[string][]byte
+}{
+	secrets: make(map[string][]byte),
+}
+
+func init() {
+	teardown.Register("clear secrets", teardown.StageKeys, func() error {
+		Clear()
+		return nil
+	})
+}
+
+// Get returns the named secret.
+func Get(name string) (val []byte, ok bool) {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	val, ok = keyring.secrets[name]
+
+	return bytes.Clone(val), ok
+}
+
+// Names returns the sorted list of secret names.
+func Names() []string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	return slices.Sorted(maps.Keys(keyring.secrets))
+}
+
+// Add adds, or replaces, the argument secrets to the keyring.
+func Add(secrets map[string][]byte) {
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	for name, val := range secrets {
+		keyring.secrets[name] = bytes.Clone(val)
+	}
+}
+
+// Clear removes, and zeroes, all secrets from the keyring.
+func Clear() {
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	for name, val := range keyring.secrets {
+		clear(val)
+		delete(keyring.secrets, name)
+	}
+}
+
+// Provision requests secrets from the key broker at the argument URL, with an
+// attestation report bound to an ephemeral key, and adds them to the keyring.
+// The response must be signed by the [BrokerKey] identity. The secret names
+// are returned on success.
+func Provision(client *http.Client, url string) (names []string, err error) {
+	if len(BrokerKey) == 0 {
+		return nil, errors.New("broker key not set")
+	}
+
+	broker, err := hex.DecodeString(BrokerKey)
+
+	if err != nil || len(broker) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid broker key")
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return
+	}
+
+	req := &attest.SecretRequest{
+		PublicKey: priv.PublicKey().Bytes(),
+	}
+
+	data := attest.SecretReportData(req.PublicKey)
+	report, certs, err := kvm.ExtendedReport(data)
+
+	if err != nil {
+		if report, err = kvm.Report(data); err != nil {
+			return nil, fmt.Errorf("could not get report, %v", err)
+		}
+	}
+
+	if _, err = attest.ParseCertTable(certs); err == nil {
+		req.Certificates = certs
+	}
+
+	req.Report = report.Bytes()
+
+	body, err := json.Marshal(req)
+
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not request secrets, %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not request secrets, %s", resp.Status)
+	}
+
+	res := &attest.SecretResponse{}
+
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(res); err != nil {
+		return nil, fmt.Errorf("could not parse response, %v", err)
+	}
+
+	secrets, err := attest.UnwrapSecrets(priv, broker, res)
+
+	if err != nil {
+		return
+	}
+
+	Add(secrets)
+
+	for name, val := range secrets {
+		names = append(names, name)
+		clear(val)
+	}
+
+	slices.Sort(names)
+
+	return
+}
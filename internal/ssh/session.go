@@ -6,18 +6,53 @@
 package ssh
 
 import (
+	"crypto/rand"
+	"fmt"
 	"io"
 	"log"
 	"os"
 
 	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/usbarmory/go-boot/shell"
+	"github.com/usbarmory/tamago-sev-example/attest"
 	"github.com/usbarmory/tamago-sev-example/internal/kvm"
 )
 
 const addr = ":22"
 
+// hostCertificate returns a signer presenting a self-signed OpenSSH host
+// certificate for the argument key, the certificate carries a fresh
+// attestation report bound to the host public key.
+func hostCertificate(signer gossh.Signer) (gossh.Signer, error) {
+	pub := signer.PublicKey()
+
+	report, err := kvm.Report(attest.SSHReportData(pub))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get report, %v", err)
+	}
+
+	cert := &gossh.Certificate{
+		Key:         pub,
+		CertType:    gossh.HostCert,
+		KeyId:       "tamago-sev-example",
+		ValidBefore: gossh.CertTimeInfinity,
+		Permissions: gossh.Permissions{
+			Extensions: map[string]string{
+				attest.SSHReportExtension: string(report.Bytes()),
+			},
+		},
+	}
+
+	if err = cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("could not sign certificate, %v", err)
+	}
+
+	return gossh.NewCertSigner(cert, signer)
+}
+
 func Start(banner string) {
 	ssh.Handle(func(s ssh.Session) {
 		c := &shell.Interface{
@@ -40,11 +75,19 @@ func Start(banner string) {
 	if err != nil {
 		// use random host key
 		err = ssh.ListenAndServe(addr, nil)
+		log.Printf("ssh server terminated, %v", err)
+		return
+	}
+
+	// use VM unique key, attested through a host certificate
+	if cert, err := hostCertificate(signer); err != nil {
+		log.Printf("could not create attested host certificate, %v", err)
 	} else {
-		// use VM unique key
-		srv.AddHostKey(signer)
-		err = srv.ListenAndServe()
+		srv.AddHostKey(cert)
 	}
 
+	srv.AddHostKey(signer)
+	err = srv.ListenAndServe()
+
 	log.Printf("ssh server terminated, %v", err)
 }
@@ -0,0 +1,217 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package svsm implements the Secure VM Service Module (SVSM) protocol
+// encoding for AMD SEV-SNP guests.
+//
+// SVSM calls are issued through a [Caller], such as the kvm package calling
+// area transport.
+package svsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// PageSize is the SVSM page size, buffers passed to the SVSM must not cross
+// page boundaries.
+const PageSize = 4096
+
+// Secure VM Service Module for SEV-SNP Guests
+// Table 2: Core Protocol Services.
+const (
+	SVSM_CORE_PROTOCOL = 0
+
+	SVSM_CORE_REMAP_CA       = 0
+	SVSM_CORE_QUERY_PROTOCOL = 1
+)
+
+// Secure VM Service Module for SEV-SNP Guests
+// Table 11: Attestation Protocol Services.
+const (
+	SVSM_ATTEST_PROTOCOL = 1
+
+	SVSM_ATTEST_SERVICES       = 0
+	SVSM_ATTEST_SINGLE_SERVICE = 1
+)
+
+// Secure VM Service Module for SEV-SNP Guests
+// Table 14: vTPM Protocol Services.
+const (
+	SVSM_VTPM_PROTOCOL = 2
+
+	SVSM_VTPM_QUERY = 0
+	SVSM_VTPM_CMD   = 1
+)
+
+// Secure VM Service Module for SEV-SNP Guests
+// Table 3: Call Result Codes.
+const (
+	SVSM_SUCCESS               = 0x00000000
+	SVSM_ERR_INCOMPLETE        = 0x80000000
+	SVSM_ERR_UNSUPPORTED_PROTO = 0x80000001
+	SVSM_ERR_UNSUPPORTED_CALL  = 0x80000002
+	SVSM_ERR_INVALID_ADDRESS   = 0x80000003
+	SVSM_ERR_INVALID_FORMAT    = 0x80000004
+	SVSM_ERR_INVALID_PARAMETER = 0x80000005
+	SVSM_ERR_INVALID_REQUEST   = 0x80000006
+	SVSM_ERR_BUSY              = 0x80000007
+	SVSM_ERR_PROTOCOL_BASE     = 0x80001000
+)
+
+// SVSM attestation buffer sizes
+const (
+	ReportSize   = 1 * PageSize
+	ManifestSize = 4 * PageSize
+	CertsSize    = 4 * PageSize
+)
+
+// Result represents an SVSM call result code.
+type Result uint64
+
+// Error implements the error interface.
+func (r Result) Error() string {
+	switch r {
+	case SVSM_ERR_INCOMPLETE:
+		return "incomplete"
+	case SVSM_ERR_UNSUPPORTED_PROTO:
+		return "unsupported protocol"
+	case SVSM_ERR_UNSUPPORTED_CALL:
+		return "unsupported call"
+	case SVSM_ERR_INVALID_ADDRESS:
+		return "invalid address"
+	case SVSM_ERR_INVALID_FORMAT:
+		return "invalid format"
+	case SVSM_ERR_INVALID_PARAMETER:
+		return "invalid parameter"
+	case SVSM_ERR_INVALID_REQUEST:
+		return "invalid request"
+	case SVSM_ERR_BUSY:
+		return "busy"
+	}
+
+	return fmt.Sprintf("SVSM error %#x", uint64(r))
+}
+
+// Caller represents an SVSM call transport.
+type Caller interface {
+	// Call issues an SVSM call with the argument protocol, call identifier
+	// and RCX, RDX, R8, R9 register values, returning the RCX, RDX and R8
+	// register values. Result codes other than SVSM_SUCCESS are returned
+	// as [Result] errors.
+	Call(protocol uint32, call uint32, rcx, rdx, r8, r9 uint64) (orcx, ordx, or8 uint64, err error)
+}
+
+// AllocFunc returns a buffer of the argument size and physical memory
+// alignment, along with its physical address.
+type AllocFunc func(size int, align int) (buf []byte, addr uint64)
+
+// Location represents an SVSM attestation buffer location.
+type Location struct {
+	Addr uint64
+	Len  uint32
+	_    uint32
+}
+
+// AttestRequest represents an SVSM attestation request
+// (Secure VM Service Module for SEV-SNP Guests - Table 12).
+type AttestRequest struct {
+	Report          Location
+	Nonce           Location
+	Manifest        Location
+	Certificates    Location
+	ServiceGUID     [16]byte
+	ManifestVersion uint32
+	_               uint32
+}
+
+// Bytes converts the descriptor structure to byte array format.
+func (r *AttestRequest) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, r)
+	return buf.Bytes()
+}
+
+// Attestation represents an SVSM attestation response.
+type Attestation struct {
+	// Report is the raw attestation report, its REPORT_DATA is the SHA-512
+	// digest of the nonce and manifest concatenation.
+	Report []byte
+	// Manifest is the services manifest.
+	Manifest []byte
+	// Certificates is the certificate table supplied by the SVSM, if any
+	// (see [attest.ParseCertTable]).
+	Certificates []byte
+}
+
+// QueryProtocol returns the minimum and maximum versions supported by the
+// SVSM for the argument protocol, both are zero when the protocol is not
+// supported.
+func QueryProtocol(c Caller, protocol uint32, version uint32) (minVersion uint16, maxVersion uint16, err error) {
+	rcx, _, _, err := c.Call(SVSM_CORE_PROTOCOL, SVSM_CORE_QUERY_PROTOCOL, uint64(protocol)<<32|uint64(version), 0, 0, 0)
+
+	if err != nil {
+		return
+	}
+
+	return uint16(rcx), uint16(rcx >> 16), nil
+}
+
+// Attest requests, through the SVSM attestation protocol, an attestation
+// report for all SVSM services, the argument nonce is bound to the report
+// together with the services manifest.
+//
+// All buffers passed to the SVSM, including the request itself, are
+// allocated page aligned so that none crosses a page boundary.
+func Attest(c Caller, alloc AllocFunc, nonce []byte) (a *Attestation, err error) {
+	if len(nonce) == 0 || len(nonce) > PageSize {
+		return nil, fmt.Errorf("invalid nonce size (%d)", len(nonce))
+	}
+
+	// buffers are retained until the call is complete
+	report, reportAddr := alloc(ReportSize, PageSize)
+	manifest, manifestAddr := alloc(ManifestSize, PageSize)
+	certs, certsAddr := alloc(CertsSize, PageSize)
+	n, nonceAddr := alloc(PageSize, PageSize)
+	buf, reqAddr := alloc(PageSize, PageSize)
+
+	copy(n, nonce)
+
+	req := &AttestRequest{
+		Report:       Location{Addr: reportAddr, Len: ReportSize},
+		Nonce:        Location{Addr: nonceAddr, Len: uint32(len(nonce))},
+		Manifest:     Location{Addr: manifestAddr, Len: ManifestSize},
+		Certificates: Location{Addr: certsAddr, Len: CertsSize},
+	}
+
+	copy(buf, req.Bytes())
+
+	reportLen, manifestLen, certsLen, err := c.Call(SVSM_ATTEST_PROTOCOL, SVSM_ATTEST_SERVICES, reqAddr, 0, 0, 0)
+
+	runtime.KeepAlive(report)
+	runtime.KeepAlive(manifest)
+	runtime.KeepAlive(certs)
+	runtime.KeepAlive(n)
+	runtime.KeepAlive(buf)
+
+	if err != nil {
+		return
+	}
+
+	if reportLen > ReportSize || manifestLen > ManifestSize || certsLen > CertsSize {
+		return nil, errors.New("invalid response sizes")
+	}
+
+	a = &Attestation{
+		Report:       report[:reportLen],
+		Manifest:     manifest[:manifestLen],
+		Certificates: certs[:certsLen],
+	}
+
+	return
+}
@@ -0,0 +1,265 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package svsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+// memory represents the fake SVSM view of guest physical memory, populated
+// by its allocator.
+type memory map[uint64][]byte
+
+// alloc mirrors the kvm package allocator, on the host physical addresses
+// are virtual ones.
+func (m memory) alloc(size int, align int) (buf []byte, addr uint64) {
+	buf = make([]byte, size+align)
+	base := uint64(uintptr(unsafe.Pointer(&buf[0])))
+	addr = (base + uint64(align) - 1) &^ (uint64(align) - 1)
+	buf = buf[addr-base:][:size]
+
+	m[addr] = buf
+
+	return
+}
+
+// at returns the allocated buffer at the argument physical address.
+func (m memory) at(t *testing.T, addr uint64, size uint32) []byte {
+	buf, ok := m[addr]
+
+	if !ok || int(size) > len(buf) {
+		t.Fatalf("invalid guest buffer (%#x, %d)", addr, size)
+	}
+
+	return buf[:size]
+}
+
+func crossesPage(addr uint64, size uint32) bool {
+	return size > 0 && addr/PageSize != (addr+uint64(size)-1)/PageSize
+}
+
+// fakeSVSM implements the SVSM side of the core and attestation protocols.
+type fakeSVSM struct {
+	t   *testing.T
+	mem memory
+
+	rax   uint64
+	rcx   uint64
+	req   *AttestRequest
+	nonce []byte
+
+	result   uint64
+	versions uint64
+	report   []byte
+	manifest []byte
+	certs    []byte
+	lengths  []uint64
+}
+
+func (s *fakeSVSM) Call(protocol uint32, call uint32, rcx, rdx, r8, r9 uint64) (orcx, ordx, or8 uint64, err error) {
+	s.rax = uint64(protocol)<<32 | uint64(call)
+	s.rcx = rcx
+
+	if s.result != SVSM_SUCCESS {
+		return 0, 0, 0, Result(s.result)
+	}
+
+	switch s.rax {
+	case SVSM_CORE_PROTOCOL<<32 | SVSM_CORE_QUERY_PROTOCOL:
+		return s.versions, 0, 0, nil
+	case SVSM_ATTEST_PROTOCOL<<32 | SVSM_ATTEST_SERVICES:
+	default:
+		return 0, 0, 0, Result(SVSM_ERR_UNSUPPORTED_CALL)
+	}
+
+	if crossesPage(rcx, 0x58) {
+		s.t.Errorf("request crosses page boundary (%#x)", rcx)
+	}
+
+	s.req = &AttestRequest{}
+
+	if _, err := binary.Decode(s.mem.at(s.t, rcx, 0x58), binary.LittleEndian, s.req); err != nil {
+		s.t.Fatal(err)
+	}
+
+	for _, l := range []Location{s.req.Report, s.req.Nonce, s.req.Manifest, s.req.Certificates} {
+		if l.Addr%PageSize != 0 {
+			s.t.Errorf("buffer not page aligned (%#x)", l.Addr)
+		}
+
+	}
+
+	if crossesPage(s.req.Nonce.Addr, s.req.Nonce.Len) {
+		s.t.Errorf("nonce crosses page boundary (%#x)", s.req.Nonce.Addr)
+	}
+
+	s.nonce = bytes.Clone(s.mem.at(s.t, s.req.Nonce.Addr, s.req.Nonce.Len))
+
+	copy(s.mem.at(s.t, s.req.Report.Addr, s.req.Report.Len), s.report)
+	copy(s.mem.at(s.t, s.req.Manifest.Addr, s.req.Manifest.Len), s.manifest)
+	copy(s.mem.at(s.t, s.req.Certificates.Addr, s.req.Certificates.Len), s.certs)
+
+	if s.lengths != nil {
+		return s.lengths[0], s.lengths[1], s.lengths[2], nil
+	}
+
+	return uint64(len(s.report)), uint64(len(s.manifest)), uint64(len(s.certs)), nil
+}
+
+func TestAttestRequestBytes(t *testing.T) {
+	req := &AttestRequest{
+		Report:          Location{Addr: 0x1000, Len: 0x11},
+		Nonce:           Location{Addr: 0x2000, Len: 0x22},
+		Manifest:        Location{Addr: 0x3000, Len: 0x33},
+		Certificates:    Location{Addr: 0x4000, Len: 0x44},
+		ServiceGUID:     [16]byte{0xaa, 15: 0xbb},
+		ManifestVersion: 0x55,
+	}
+
+	buf := req.Bytes()
+
+	// Secure VM Service Module for SEV-SNP Guests - Table 12
+	if len(buf) != 0x58 {
+		t.Fatalf("invalid size %#x", len(buf))
+	}
+
+	for _, f := range []struct {
+		off  int
+		size int
+		val  uint64
+	}{
+		{0x00, 8, 0x1000}, {0x08, 4, 0x11}, {0x0c, 4, 0},
+		{0x10, 8, 0x2000}, {0x18, 4, 0x22}, {0x1c, 4, 0},
+		{0x20, 8, 0x3000}, {0x28, 4, 0x33}, {0x2c, 4, 0},
+		{0x30, 8, 0x4000}, {0x38, 4, 0x44}, {0x3c, 4, 0},
+		{0x40, 1, 0xaa}, {0x4f, 1, 0xbb},
+		{0x50, 4, 0x55}, {0x54, 4, 0},
+	} {
+		var val uint64
+
+		switch f.size {
+		case 1:
+			val = uint64(buf[f.off])
+		case 4:
+			val = uint64(binary.LittleEndian.Uint32(buf[f.off:]))
+		case 8:
+			val = binary.LittleEndian.Uint64(buf[f.off:])
+		}
+
+		if val != f.val {
+			t.Errorf("offset %#x: got %#x, want %#x", f.off, val, f.val)
+		}
+	}
+}
+
+func TestQueryProtocol(t *testing.T) {
+	s := &fakeSVSM{t: t, versions: 3<<16 | 1}
+
+	minVersion, maxVersion, err := QueryProtocol(s, SVSM_ATTEST_PROTOCOL, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.rax != SVSM_CORE_PROTOCOL<<32|SVSM_CORE_QUERY_PROTOCOL {
+		t.Errorf("invalid RAX %#x", s.rax)
+	}
+
+	if s.rcx != SVSM_ATTEST_PROTOCOL<<32|1 {
+		t.Errorf("invalid RCX %#x", s.rcx)
+	}
+
+	if minVersion != 1 || maxVersion != 3 {
+		t.Errorf("invalid versions %d-%d", minVersion, maxVersion)
+	}
+}
+
+func TestAttest(t *testing.T) {
+	s := &fakeSVSM{
+		t:        t,
+		mem:      memory{},
+		report:   bytes.Repeat([]byte{0x11}, 0x4a0),
+		manifest: []byte("manifest"),
+		certs:    bytes.Repeat([]byte{0x33}, PageSize+1),
+	}
+
+	nonce := []byte("nonce")
+	a, err := Attest(s, s.mem.alloc, nonce)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.rax != SVSM_ATTEST_PROTOCOL<<32|SVSM_ATTEST_SERVICES {
+		t.Errorf("invalid RAX %#x", s.rax)
+	}
+
+	if s.rcx%PageSize != 0 {
+		t.Errorf("request not page aligned (%#x)", s.rcx)
+	}
+
+	if !bytes.Equal(s.nonce, nonce) {
+		t.Errorf("invalid nonce %x", s.nonce)
+	}
+
+	if s.req.Report.Len != ReportSize || s.req.Manifest.Len != ManifestSize || s.req.Certificates.Len != CertsSize {
+		t.Errorf("invalid buffer sizes %+v", s.req)
+	}
+
+	if !bytes.Equal(a.Report, s.report) || !bytes.Equal(a.Manifest, s.manifest) || !bytes.Equal(a.Certificates, s.certs) {
+		t.Error("invalid attestation")
+	}
+}
+
+func TestAttestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		nonce []byte
+		svsm  *fakeSVSM
+		err   error
+	}{
+		{"empty nonce", nil, &fakeSVSM{}, nil},
+		{"large nonce", make([]byte, PageSize+1), &fakeSVSM{}, nil},
+		{"busy", []byte{1}, &fakeSVSM{result: SVSM_ERR_BUSY}, Result(SVSM_ERR_BUSY)},
+		{"report size", []byte{1}, &fakeSVSM{lengths: []uint64{ReportSize + 1, 0, 0}}, nil},
+		{"manifest size", []byte{1}, &fakeSVSM{lengths: []uint64{0, ManifestSize + 1, 0}}, nil},
+		{"certs size", []byte{1}, &fakeSVSM{lengths: []uint64{0, 0, CertsSize + 1}}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.svsm.t = t
+			tt.svsm.mem = memory{}
+
+			a, err := Attest(tt.svsm, tt.svsm.mem.alloc, tt.nonce)
+
+			if err == nil || a != nil {
+				t.Fatal("expected error")
+			}
+
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestResultError(t *testing.T) {
+	for _, tt := range []struct {
+		res  Result
+		want string
+	}{
+		{SVSM_ERR_INVALID_ADDRESS, "invalid address"},
+		{SVSM_ERR_BUSY, "busy"},
+		{SVSM_ERR_PROTOCOL_BASE + 1, "SVSM error 0x80001001"},
+	} {
+		if got := tt.res.Error(); got != tt.want {
+			t.Errorf("%#x: got %q, want %q", uint64(tt.res), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package teardown implements a registry of hooks, executed before a system
+// reset or shutdown, which allow subsystems to stop devices and scrub
+// sensitive memory.
+package teardown
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Stage represents a teardown stage, hooks are executed in stage order.
+type Stage int
+
+// Teardown stages
+const (
+	// StageDevices stops devices (e.g. network interfaces), which must no
+	// longer access shared memory once scrubbed.
+	StageDevices Stage = iota
+	// StageKeys zeroes key material and secrets.
+	StageKeys
+	// StageShared scrubs shared (unencrypted) memory.
+	StageShared
+	// StageSecrets wipes the platform secrets (e.g. VMPCKs), as last
+	// stage as guest requests are no longer possible afterwards.
+	StageSecrets
+)
+
+// Hook represents a teardown hook.
+type Hook struct {
+	// Name is the hook identifier.
+	Name string
+	// Stage is the hook execution stage.
+	Stage Stage
+	// Fn is the hook function.
+	Fn func() error
+}
+
+var hooks struct {
+	sync.Mutex
+
+	list []Hook
+	done bool
+}
+
+// Register adds a teardown hook, hooks within the same stage are executed in
+// registration order.
+func Register(name string, stage Stage, fn func() error) {
+	hooks.Lock()
+	defer hooks.Unlock()
+
+	hooks.list = append(hooks.list, Hook{
+		Name:  name,
+		Stage: stage,
+		Fn:    fn,
+	})
+}
+
+// Hooks returns the registered hooks in execution order.
+func Hooks() (list []Hook) {
+	hooks.Lock()
+	defer hooks.Unlock()
+
+	list = append(list, hooks.list...)
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Stage < list[j].Stage
+	})
+
+	return
+}
+
+// Run executes all registered hooks, once, in stage order. Failing hooks do
+// not prevent execution of the following ones, all errors are returned.
+func Run() (err error) {
+	var errs []error
+
+	list := Hooks()
+
+	hooks.Lock()
+	done := hooks.done
+	hooks.done = true
+	hooks.Unlock()
+
+	if done {
+		return
+	}
+
+	for _, h := range list {
+		log.Printf("teardown: %s", h.Name)
+
+		if e := h.Fn(); e != nil {
+			errs = append(errs, fmt.Errorf("%s, %v", h.Name, e))
+		}
+	}
+
+	return errors.Join(errs...)
+}
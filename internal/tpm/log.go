@@ -0,0 +1,69 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// Event represents a measurement event.
+type Event struct {
+	// PCR is the extended PCR index.
+	PCR int
+	// Type is the event type (e.g. "file" or "command").
+	Type string
+	// Description is the measured object name.
+	Description string
+	// Digest is the SHA-256 digest extended in the PCR.
+	Digest [DigestSize]byte
+}
+
+// EventLog represents a measured boot event log.
+type EventLog struct {
+	sync.Mutex
+	events []Event
+}
+
+// Measure extends the SHA-256 digest of the argument data in a PCR and
+// records the event, the event is not recorded on extension errors.
+func (l *EventLog) Measure(t *TPM, pcr int, typ string, desc string, data []byte) (err error) {
+	sum := sha256.Sum256(data)
+
+	l.Lock()
+	defer l.Unlock()
+
+	if err = t.Extend(pcr, sum[:]); err != nil {
+		return
+	}
+
+	l.events = append(l.events, Event{
+		PCR:         pcr,
+		Type:        typ,
+		Description: desc,
+		Digest:      sum,
+	})
+
+	return
+}
+
+// Events returns all recorded events.
+func (l *EventLog) Events() []Event {
+	l.Lock()
+	defer l.Unlock()
+
+	return append([]Event(nil), l.events...)
+}
+
+// Replay returns the expected SHA-256 PCR values resulting from all recorded
+// events, assuming all PCRs were initially zero.
+func (l *EventLog) Replay() (pcrs [NumPCR][DigestSize]byte) {
+	for _, e := range l.Events() {
+		pcrs[e.PCR] = sha256.Sum256(append(pcrs[e.PCR][:], e.Digest[:]...))
+	}
+
+	return
+}
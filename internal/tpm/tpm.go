@@ -0,0 +1,374 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package tpm implements a minimal TPM 2.0 client, limited to the commands
+// required for measured boot and quoting with a SHA-256 PCR bank.
+//
+// Commands are exchanged over a [Transport], such as the SVSM vTPM protocol
+// or a TPM simulator.
+package tpm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// Trusted Platform Module Library Part 2: Structures
+// 6.9 TPM_ST (Structure Tags).
+const (
+	TPM_ST_NO_SESSIONS = 0x8001
+	TPM_ST_SESSIONS    = 0x8002
+)
+
+// Trusted Platform Module Library Part 2: Structures
+// 6.5.2 TPM_CC Listing.
+const (
+	TPM_CC_CreatePrimary = 0x00000131
+	TPM_CC_Startup       = 0x00000144
+	TPM_CC_Quote         = 0x00000158
+	TPM_CC_FlushContext  = 0x00000165
+	TPM_CC_PCR_Read      = 0x0000017e
+	TPM_CC_PCR_Extend    = 0x00000182
+)
+
+// Trusted Platform Module Library Part 2: Structures
+// 6.3 TPM_ALG_ID, 6.4 TPM_ECC_CURVE.
+const (
+	TPM_ALG_SHA256 = 0x000b
+	TPM_ALG_NULL   = 0x0010
+	TPM_ALG_ECDSA  = 0x0018
+	TPM_ALG_ECC    = 0x0023
+
+	TPM_ECC_NIST_P256 = 0x0003
+)
+
+// Trusted Platform Module Library Part 2: Structures
+// 6.6 TPM_RC, 7.4 TPM_RH, 6.5 TPM_SU.
+const (
+	TPM_RC_SUCCESS    = 0x000
+	TPM_RC_INITIALIZE = 0x100
+
+	TPM_RH_ENDORSEMENT = 0x4000000b
+	TPM_RS_PW          = 0x40000009
+
+	TPM_SU_CLEAR = 0x0000
+)
+
+// Trusted Platform Module Library Part 2: Structures
+// 8.3 TPMA_OBJECT (Object Attributes).
+const (
+	fixedTPM            = 1 << 1
+	fixedParent         = 1 << 4
+	sensitiveDataOrigin = 1 << 5
+	userWithAuth        = 1 << 6
+	restricted          = 1 << 16
+	sign                = 1 << 18
+
+	akAttributes = fixedTPM | fixedParent | sensitiveDataOrigin | userWithAuth | restricted | sign
+)
+
+const (
+	// NumPCR represents the number of PCRs in a bank.
+	NumPCR = 24
+	// DigestSize represents the SHA-256 PCR bank digest size.
+	DigestSize = sha256.Size
+
+	selectSize = 3
+)
+
+// Transport represents a TPM command interface.
+type Transport interface {
+	// Send transmits a marshaled TPM command and returns its response.
+	Send(cmd []byte) (rsp []byte, err error)
+}
+
+// Error represents a TPM response code.
+type Error uint32
+
+// Error implements the error interface.
+func (rc Error) Error() string {
+	return fmt.Sprintf("TPM error %#x", uint32(rc))
+}
+
+// TPM represents a TPM 2.0 device.
+type TPM struct {
+	Transport
+}
+
+// Quote represents a TPM2_Quote response.
+type Quote struct {
+	// Attest is the marshaled TPMS_ATTEST structure being signed.
+	Attest []byte
+	// R is the ECDSA signature R component.
+	R []byte
+	// S is the ECDSA signature S component.
+	S []byte
+}
+
+// Verify checks the quote signature against the argument public key.
+func (q *Quote) Verify(pub *ecdsa.PublicKey) bool {
+	sum := sha256.Sum256(q.Attest)
+	r := new(big.Int).SetBytes(q.R)
+	s := new(big.Int).SetBytes(q.S)
+
+	return ecdsa.Verify(pub, sum[:], r, s)
+}
+
+// pcrSelection marshals a TPML_PCR_SELECTION for the SHA-256 bank.
+func pcrSelection(b *cryptobyte.Builder, pcrs []int) {
+	var sel [selectSize]byte
+
+	for _, pcr := range pcrs {
+		sel[pcr/8] |= 1 << (pcr % 8)
+	}
+
+	b.AddUint32(1)
+	b.AddUint16(TPM_ALG_SHA256)
+	b.AddUint8(selectSize)
+	b.AddBytes(sel[:])
+}
+
+// passwordSession marshals an empty password authorization area.
+func passwordSession(b *cryptobyte.Builder) {
+	b.AddUint32LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint32(TPM_RS_PW)
+		b.AddUint16(0) // nonce
+		b.AddUint8(0)  // session attributes
+		b.AddUint16(0) // hmac
+	})
+}
+
+// run marshals and executes a TPM command, returning the response handle area
+// and parameters.
+func (t *TPM) run(cc uint32, handles []uint32, auth bool, params func(b *cryptobyte.Builder)) (rsp cryptobyte.String, err error) {
+	var size uint32
+
+	tag := uint16(TPM_ST_NO_SESSIONS)
+
+	if auth {
+		tag = TPM_ST_SESSIONS
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16(tag)
+	b.AddUint32(0) // size placeholder
+	b.AddUint32(cc)
+
+	for _, h := range handles {
+		b.AddUint32(h)
+	}
+
+	if auth {
+		passwordSession(b)
+	}
+
+	if params != nil {
+		params(b)
+	}
+
+	cmd, err := b.Bytes()
+
+	if err != nil {
+		return
+	}
+
+	size = uint32(len(cmd))
+	cmd[2], cmd[3], cmd[4], cmd[5] = byte(size>>24), byte(size>>16), byte(size>>8), byte(size)
+
+	buf, err := t.Send(cmd)
+
+	if err != nil {
+		return
+	}
+
+	var rc uint32
+
+	rsp = cryptobyte.String(buf)
+
+	if !rsp.ReadUint16(&tag) || !rsp.ReadUint32(&size) || !rsp.ReadUint32(&rc) || int(size) != len(buf) {
+		return nil, errors.New("invalid response header")
+	}
+
+	if rc != TPM_RC_SUCCESS {
+		return nil, Error(rc)
+	}
+
+	return
+}
+
+// Startup issues a TPM2_Startup(CLEAR) command, an already initialized TPM
+// is not treated as an error.
+func (t *TPM) Startup() (err error) {
+	_, err = t.run(TPM_CC_Startup, nil, false, func(b *cryptobyte.Builder) {
+		b.AddUint16(TPM_SU_CLEAR)
+	})
+
+	if rc, ok := err.(Error); ok && rc == TPM_RC_INITIALIZE {
+		err = nil
+	}
+
+	return
+}
+
+// Extend extends the argument SHA-256 PCR with a digest.
+func (t *TPM) Extend(pcr int, digest []byte) (err error) {
+	if pcr < 0 || pcr >= NumPCR || len(digest) != DigestSize {
+		return errors.New("invalid arguments")
+	}
+
+	_, err = t.run(TPM_CC_PCR_Extend, []uint32{uint32(pcr)}, true, func(b *cryptobyte.Builder) {
+		b.AddUint32(1)
+		b.AddUint16(TPM_ALG_SHA256)
+		b.AddBytes(digest)
+	})
+
+	return
+}
+
+// Read returns the value of the argument SHA-256 PCR.
+func (t *TPM) Read(pcr int) (digest []byte, err error) {
+	var counter, count uint32
+	var sel, val cryptobyte.String
+
+	if pcr < 0 || pcr >= NumPCR {
+		return nil, errors.New("invalid PCR index")
+	}
+
+	rsp, err := t.run(TPM_CC_PCR_Read, nil, false, func(b *cryptobyte.Builder) {
+		pcrSelection(b, []int{pcr})
+	})
+
+	if err != nil {
+		return
+	}
+
+	if !rsp.ReadUint32(&counter) || !rsp.ReadUint32(&count) || count != 1 || !rsp.Skip(2) || !rsp.ReadUint8LengthPrefixed(&sel) {
+		return nil, errors.New("invalid PCR selection")
+	}
+
+	if !rsp.ReadUint32(&count) || count != 1 || !rsp.ReadUint16LengthPrefixed(&val) {
+		return nil, errors.New("invalid PCR digest")
+	}
+
+	return val, nil
+}
+
+// CreateAK creates a primary, restricted ECDSA P-256 signing key in the
+// endorsement hierarchy, suitable as Attestation Key. The returned handle
+// should be released with [TPM.Flush].
+func (t *TPM) CreateAK() (handle uint32, pub *ecdsa.PublicKey, err error) {
+	var public, policy, x, y cryptobyte.String
+	var params uint32
+
+	rsp, err := t.run(TPM_CC_CreatePrimary, []uint32{TPM_RH_ENDORSEMENT}, true, func(b *cryptobyte.Builder) {
+		// TPM2B_SENSITIVE_CREATE
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(0) // userAuth
+			b.AddUint16(0) // data
+		})
+
+		// TPM2B_PUBLIC
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(TPM_ALG_ECC)
+			b.AddUint16(TPM_ALG_SHA256)
+			b.AddUint32(akAttributes)
+			b.AddUint16(0) // authPolicy
+			b.AddUint16(TPM_ALG_NULL)
+			b.AddUint16(TPM_ALG_ECDSA)
+			b.AddUint16(TPM_ALG_SHA256)
+			b.AddUint16(TPM_ECC_NIST_P256)
+			b.AddUint16(TPM_ALG_NULL) // kdf
+			b.AddUint16(0)            // unique.x
+			b.AddUint16(0)            // unique.y
+		})
+
+		b.AddUint16(0) // outsideInfo
+		b.AddUint32(0) // creationPCR
+	})
+
+	if err != nil {
+		return
+	}
+
+	if !rsp.ReadUint32(&handle) || !rsp.ReadUint32(&params) || !rsp.ReadUint16LengthPrefixed(&public) {
+		return 0, nil, errors.New("invalid response")
+	}
+
+	// skip type, nameAlg, objectAttributes, authPolicy, symmetric, scheme,
+	// scheme hash, curve and kdf
+	if !public.Skip(2+2+4) || !public.ReadUint16LengthPrefixed(&policy) || !public.Skip(2+2+2+2+2) {
+		t.Flush(handle)
+		return 0, nil, errors.New("invalid public area")
+	}
+
+	if !public.ReadUint16LengthPrefixed(&x) || !public.ReadUint16LengthPrefixed(&y) {
+		t.Flush(handle)
+		return 0, nil, errors.New("invalid public point")
+	}
+
+	pub = &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+
+	return
+}
+
+// Flush releases a transient object handle.
+func (t *TPM) Flush(handle uint32) (err error) {
+	_, err = t.run(TPM_CC_FlushContext, nil, false, func(b *cryptobyte.Builder) {
+		b.AddUint32(handle)
+	})
+
+	return
+}
+
+// Quote requests a signature, with the argument key handle, over the
+// SHA-256 bank selected PCRs and qualifying data.
+func (t *TPM) Quote(handle uint32, data []byte, pcrs []int) (q *Quote, err error) {
+	var params uint32
+	var attest, r, s cryptobyte.String
+	var alg uint16
+
+	for _, pcr := range pcrs {
+		if pcr < 0 || pcr >= NumPCR {
+			return nil, errors.New("invalid PCR index")
+		}
+	}
+
+	rsp, err := t.run(TPM_CC_Quote, []uint32{handle}, true, func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(data)
+		})
+
+		b.AddUint16(TPM_ALG_NULL) // key scheme
+		pcrSelection(b, pcrs)
+	})
+
+	if err != nil {
+		return
+	}
+
+	if !rsp.ReadUint32(&params) || !rsp.ReadUint16LengthPrefixed(&attest) || !rsp.ReadUint16(&alg) {
+		return nil, errors.New("invalid response")
+	}
+
+	if alg != TPM_ALG_ECDSA || !rsp.Skip(2) || !rsp.ReadUint16LengthPrefixed(&r) || !rsp.ReadUint16LengthPrefixed(&s) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return &Quote{
+		Attest: attest,
+		R:      r,
+		S:      s,
+	}, nil
+}
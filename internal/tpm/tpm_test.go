@@ -0,0 +1,205 @@
+// Copyright (c) The tamago-sev-example authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package tpm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/google/go-tpm-tools/simulator"
+)
+
+const maxResponseSize = 4096
+
+// simTransport exchanges commands with the reference TPM simulator.
+type simTransport struct {
+	sim *simulator.Simulator
+}
+
+func (s *simTransport) Send(cmd []byte) (rsp []byte, err error) {
+	if _, err = s.sim.Write(cmd); err != nil {
+		return
+	}
+
+	rsp = make([]byte, maxResponseSize)
+	n, err := s.sim.Read(rsp)
+
+	return rsp[:n], err
+}
+
+func newTPM(t *testing.T) *TPM {
+	sim, err := simulator.Get()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sim.Close() })
+
+	return &TPM{Transport: &simTransport{sim: sim}}
+}
+
+func TestStartup(t *testing.T) {
+	tpm := newTPM(t)
+
+	// the simulator is already started
+	if err := tpm.Startup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tpm.Startup(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	tpm := newTPM(t)
+	digest := sha256.Sum256([]byte("measurement"))
+
+	if err := tpm.Extend(8, digest[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := tpm.Read(8)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256(append(make([]byte, DigestSize), digest[:]...))
+
+	if !bytes.Equal(val, want[:]) {
+		t.Errorf("got %x, want %x", val, want)
+	}
+}
+
+func TestInvalidArguments(t *testing.T) {
+	tpm := newTPM(t)
+	digest := make([]byte, DigestSize)
+
+	if err := tpm.Extend(NumPCR, digest); err == nil {
+		t.Error("Extend: expected error on invalid PCR")
+	}
+
+	if err := tpm.Extend(8, digest[1:]); err == nil {
+		t.Error("Extend: expected error on invalid digest")
+	}
+
+	if _, err := tpm.Read(-1); err == nil {
+		t.Error("Read: expected error on invalid PCR")
+	}
+
+	if _, err := tpm.Quote(0, nil, []int{NumPCR}); err == nil {
+		t.Error("Quote: expected error on invalid PCR")
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	var log EventLog
+
+	tpm := newTPM(t)
+
+	for _, e := range []struct {
+		pcr  int
+		desc string
+	}{
+		{8, "ls"},
+		{9, "kernel.efi"},
+		{8, "cat"},
+	} {
+		if err := log.Measure(tpm, e.pcr, "test", e.desc, []byte(e.desc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := log.Measure(tpm, NumPCR, "test", "invalid", nil); err == nil {
+		t.Error("expected error on invalid PCR")
+	}
+
+	if n := len(log.Events()); n != 3 {
+		t.Errorf("got %d events, want 3", n)
+	}
+
+	expected := log.Replay()
+
+	for pcr := range NumPCR {
+		val, err := tpm.Read(pcr)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the simulator PCRs 17-22 are reset to all ones
+		if pcr >= 17 && pcr <= 22 {
+			continue
+		}
+
+		if !bytes.Equal(val, expected[pcr][:]) {
+			t.Errorf("PCR %d: got %x, want %x", pcr, val, expected[pcr])
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tpm := newTPM(t)
+	nonce := []byte("nonce")
+	digest := sha256.Sum256([]byte("measurement"))
+
+	if err := tpm.Extend(8, digest[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	handle, pub, err := tpm.CreateAK()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tpm.Flush(handle)
+
+	q, err := tpm.Quote(handle, nonce, []int{8, 9})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !q.Verify(pub) {
+		t.Fatal("invalid quote signature")
+	}
+
+	if !bytes.Contains(q.Attest, nonce) {
+		t.Error("quote does not include qualifying data")
+	}
+
+	q.Attest[len(q.Attest)-1] ^= 0xff
+
+	if q.Verify(pub) {
+		t.Error("tampered quote verified")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	tpm := newTPM(t)
+
+	handle, _, err := tpm.CreateAK()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tpm.Flush(handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tpm.Quote(handle, nil, []int{8}); err == nil {
+		t.Error("expected error on flushed handle")
+	}
+
+	if _, ok := err.(Error); !ok {
+		t.Errorf("got %T, want Error", err)
+	}
+}
@@ -18,8 +18,22 @@ import (
 
 	"github.com/usbarmory/tamago-sev-example/cmd"
 	"github.com/usbarmory/tamago-sev-example/internal/kvm"
+	"github.com/usbarmory/tamago-sev-example/internal/secrets"
+	"github.com/usbarmory/tamago-sev-example/internal/teardown"
 )
 
+// APCreation selects, when set, unikernel AP creation (see kvm.InitSMP) over
+// EFI MP services under AMD SEV-SNP.
+var APCreation string
+
+// Network is, when set, the shell command executed at boot to start
+// networking (e.g. `net-virtio 10.0.0.1/24 : 10.0.0.2`).
+var Network string
+
+// Secrets is, when set, the key broker URL from which secrets are
+// provisioned at boot (see secrets.Provision), after networking is started.
+var Secrets string
+
 func init() {
 	log.SetFlags(0)
 	log.SetOutput(x64.UART0)
@@ -29,13 +43,10 @@ func main() {
 	// disable UEFI watchdog
 	x64.UEFI.Boot.SetWatchdogTimer(0)
 
-	// This unikernel does not set its own (*GHCB).Layout and therefore
-	// relies on the previous GHCB GPA as initialized by OVMF as well as
-	// its #VC handler.
-	//
-	// For this reason any GHCB call through github.com/usbarmory/kvm/sev
-	// might overlap with OVMF #VC handling and result in an error
-	// (particularly under SMP).
+	// This unikernel registers its own GHCB GPA for each vCPU (see
+	// kvm.WithGHCB), while EFI boot services are available the GHCB GPA
+	// initialized by OVMF is registered again after each use as required
+	// by its #VC handler.
 	if sev.Features(x64.AMD64).SEV.SNP {
 		if err := kvm.InitGHCB(); err != nil {
 			log.Printf("could not initialize GHCB, %v", err)
@@ -44,16 +55,39 @@ func main() {
 		x64.AllocateDMA(10 << 20)
 	}
 
-	x64.InitSMP()
+	if len(APCreation) > 0 && kvm.GHCB != nil {
+		// APs are created after exiting EFI boot services (see
+		// cmd.APCreation)
+		cmd.APCreation = true
+	} else {
+		x64.InitSMP()
+	}
 
 	console := &shell.Interface{
 		Banner:     cmd.Banner,
 		ReadWriter: x64.UART0,
+		Output:     x64.UART0,
+	}
+
+	if len(Network) > 0 {
+		console.Exec([]byte(Network))
+	}
+
+	if len(Secrets) > 0 {
+		if names, err := secrets.Provision(nil, Secrets); err != nil {
+			log.Printf("could not provision secrets, %v", err)
+		} else {
+			log.Printf("provisioned %d secrets %v", len(names), names)
+		}
 	}
 
 	// start interactive shell
 	console.Start(true)
 
+	if err := teardown.Run(); err != nil {
+		log.Printf("teardown incomplete, %v", err)
+	}
+
 	if x64.Console.Out != 0 {
 		x64.UEFI.Runtime.ResetSystem(uefi.EfiResetShutdown)
 	}